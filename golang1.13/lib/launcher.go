@@ -60,16 +60,30 @@ func main() {
 	defer out.Close()
 	reader := bufio.NewReader(os.Stdin)
 
+	// protocol version 2 frames the messages with the request id, if requested
+	framed := os.Getenv("__OW_PROTOCOL") == "2"
+
 	// acknowledgement of started action
-	fmt.Fprintf(out, `{ "ok": true}%s`, "\n")
+	if framed {
+		fmt.Fprintf(out, `{ "ok": true, "protocol": 2}%s`, "\n")
+	} else {
+		fmt.Fprintf(out, `{ "ok": true}%s`, "\n")
+	}
 	if debug {
 		log.Println("action started")
 	}
 
 	// read-eval-print loop
 	for {
-		// read one line
-		inbuf, err := reader.ReadBytes('\n')
+		// read one line, or one frame
+		var id uint64
+		var inbuf []byte
+		var err error
+		if framed {
+			id, inbuf, err = readFrame(reader)
+		} else {
+			inbuf, err = reader.ReadBytes('\n')
+		}
 		if err != nil {
 			if err != io.EOF {
 				log.Println(err)
@@ -84,7 +98,7 @@ func main() {
 		err = json.Unmarshal(inbuf, &input)
 		if err != nil {
 			log.Println(err.Error())
-			fmt.Fprintf(out, "{ error: %q}\n", err.Error())
+			reply(out, framed, id, []byte(fmt.Sprintf("{ error: %q}", err.Error())))
 			continue
 		}
 		if debug {
@@ -110,13 +124,37 @@ func main() {
 		output, err := json.Marshal(&result)
 		if err != nil {
 			log.Println(err.Error())
-			fmt.Fprintf(out, "{ error: %q}\n", err.Error())
+			reply(out, framed, id, []byte(fmt.Sprintf("{ error: %q}", err.Error())))
 			continue
 		}
 		output = bytes.Replace(output, []byte("\n"), []byte(""), -1)
 		if debug {
 			log.Printf("<<<'%s'<<<", output)
 		}
-		fmt.Fprintf(out, "%s\n", output)
+		reply(out, framed, id, output)
+	}
+}
+
+// readFrame reads a message of protocol version 2:
+// a header line with the request id and the length of the payload, then the payload and a newline
+func readFrame(reader *bufio.Reader) (uint64, []byte, error) {
+	var id uint64
+	var length int
+	if _, err := fmt.Fscanf(reader, "%d %d\n", &id, &length); err != nil {
+		return 0, nil, err
+	}
+	buf := make([]byte, length+1)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return 0, nil, err
+	}
+	return id, buf[:length], nil
+}
+
+// reply writes the answer to a request, framed with its id with protocol version 2
+func reply(out io.Writer, framed bool, id uint64, msg []byte) {
+	if framed {
+		fmt.Fprintf(out, "%d %d\n%s\n", id, len(msg), msg)
+	} else {
+		fmt.Fprintf(out, "%s\n", msg)
 	}
 }
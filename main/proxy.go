@@ -19,8 +19,10 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/apache/openwhisk-runtime-go/openwhisk"
 )
@@ -29,8 +31,12 @@ import (
 var version = flag.Bool("version", false, "show version")
 
 // flag to enable debug
-//这里我们把debug改成true了。真正到openwhisk的时候：改回false
-var debug = flag.Bool("debug", true, "enable debug output")
+var debug = flag.Bool("debug", false, "enable debug output")
+
+// flags to select the log lines and their format
+var logLevel = flag.String("log-level", envString("OW_LOG_LEVEL", "warn"), "level of the log lines: debug, info, warn or error, optionally followed by subsystem=level pairs separated by commas")
+var logFormat = flag.String("log-format", envString("OW_LOG_FORMAT", openwhisk.LogfmtFormat), "format of the log lines: logfmt or json")
+var logFile = flag.String("log-file", os.Getenv("OW_LOG_FILE"), "file receiving the log lines, instead of the standard error shared with the actions")
 
 // flag to require on-the-fly compilation
 var compile = flag.String("compile", "", "compile, reading in standard input the specified function, and producing the result in stdout")
@@ -38,14 +44,109 @@ var compile = flag.String("compile", "", "compile, reading in standard input the
 // flag to pass an environment as a json string
 var env = flag.String("env", "", "pass an environment as a json string")
 
+// flag to load the catalog of the preloadable models
+var models = flag.String("models", os.Getenv("OW_MODELS"), "JSON file declaring the models that can be preloaded")
+
+// flag to keep several models loaded within a memory budget
+var memoryBudget = flag.Uint64("memory-budget", envUint64("OW_MEMORY_BUDGET_MB"), "memory in MB the preloaded models can use, 0 keeps only the model being served")
+
+// flag to limit the requests waiting for a busy model
+var queueDepth = flag.Int("queue-depth", int(envUint64("OW_QUEUE_DEPTH")), "requests that can wait for a busy model, 0 for unlimited")
+
+// flag to give the stopped processes time to terminate before killing them
+var stopGrace = flag.Uint64("stop-grace-ms", envUint64("OW_STOP_GRACE_MS"), "milliseconds a stopped process can take to terminate after SIGTERM, 0 for the default")
+
+// flag to fork the commands of the models from a zygote
+var zygote = flag.String("zygote", os.Getenv("OW_ZYGOTE"), "command of the zygote forking the commands of the models declaring it")
+
+// flags to keep generic processes ready to be specialized in the models
+var stem = flag.String("stem", os.Getenv("OW_STEM"), "command of the stem cells specialized in the models declaring it")
+var stemCells = flag.Int("stem-cells", int(envUint64("OW_STEM_CELLS")), "stem cells kept ready, 0 for the default of 1")
+
+// flag to record the requests served, to be replayed
+var record = flag.String("record", os.Getenv("OW_RECORD"), "JSONL file recording the /init, /load, /offload, /run and /clean requests, to be replayed")
+
+// envUint64 reads a number from the environment, 0 if not set or invalid
+func envUint64(name string) uint64 {
+	n, _ := strconv.ParseUint(os.Getenv(name), 10, 64)
+	return n
+}
+
+// envString reads a string from the environment, def if not set
+func envString(name string, def string) string {
+	if s := os.Getenv(name); s != "" {
+		return s
+	}
+	return def
+}
+
 // fatal if error
 func fatalIf(err error) {
 	if err != nil {
-		log.Fatal(err)
+		openwhisk.NewLogger("proxy").Errorf("%v", err)
+		os.Exit(1)
+	}
+}
+
+// setLogLevels parses levels like "info,executor=debug,http=warn"
+func setLogLevels(levels string) error {
+	for _, item := range strings.Split(levels, ",") {
+		subsystem, name := "", strings.TrimSpace(item)
+		if i := strings.Index(name, "="); i >= 0 {
+			subsystem, name = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+		}
+		level, err := openwhisk.ParseLogLevel(name)
+		if err != nil {
+			return err
+		}
+		openwhisk.SetLogLevel(subsystem, level)
+	}
+	return nil
+}
+
+// replay replays the calls recorded with -record against a running proxy,
+// then prints how the requests to each model were served
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:8080", "url of the proxy")
+	speed := flags.Float64("speed", 1, "divides the original inter-arrival times, 0 to send the calls as fast as possible, one after the other")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay [flags] <recorded.jsonl>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *speed < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer in.Close()
+	calls, err := openwhisk.ReadRecordedCalls(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flags.Arg(0), err)
+		return 1
 	}
+	openwhisk.Replay(calls, *url, *speed).Print(os.Stdout)
+	return 0
 }
 
 func main() {
+	// execute a command within its limits, when started in its place
+	openwhisk.ExecLimitedCommand()
+
+	// play a simulated model, when started to
+	openwhisk.PlaySimulatedModel()
+
+	// replay the requests recorded
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+
 	flag.Parse()
 
 	// show version number
@@ -54,10 +155,21 @@ func main() {
 		return
 	}
 
+	// logging
+	if *logFile != "" {
+		out, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		fatalIf(err)
+		openwhisk.SetLogOutput(out)
+	}
+	fatalIf(openwhisk.SetLogFormat(*logFormat))
+	fatalIf(setLogLevels(*logLevel))
+	openwhisk.ToggleDebugOnSignal()
+
 	// debugging
 	if *debug {
 		// set debugging flag, propagated to the actions
 		openwhisk.Debugging = true
+		openwhisk.SetLogLevel("", openwhisk.LevelDebug)
 		os.Setenv("OW_DEBUG", "1")
 	}
 
@@ -66,6 +178,18 @@ func main() {
 	//编译器（从环境变量 OW_COMPILER 中获取）、标准输出流和标准错误流
 	ap := openwhisk.NewActionProxy("./action", os.Getenv("OW_COMPILER"), os.Stdout, os.Stderr)
 
+	// replace the default models with the catalog
+	if *models != "" {
+		specs, err := openwhisk.LoadModelCatalog(*models)
+		fatalIf(err)
+		ap.SetModels(specs)
+	}
+	ap.SetMemoryBudget(*memoryBudget)
+	ap.SetQueueDepth(*queueDepth)
+	if *stopGrace > 0 {
+		openwhisk.DefaultStopGrace = time.Duration(*stopGrace) * time.Millisecond
+	}
+
 	// compile on the fly upon request
 	//IMPORTANT!!! What is "*compile"? Is it from ContainerProxy?
 	if *compile != "" {
@@ -73,8 +197,29 @@ func main() {
 		return //返回编译结果
 	}
 
+	// fork the commands of the models from the zygote
+	if *zygote != "" {
+		fatalIf(ap.StartZygote(*zygote))
+	}
+
+	// keep the stem cells ready
+	if *stem != "" {
+		cells := *stemCells
+		if cells <= 0 {
+			cells = 1
+		}
+		ap.SetStemCells(cells, *stem)
+	}
+
+	// record the requests
+	if *record != "" {
+		out, err := os.OpenFile(*record, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		fatalIf(err)
+		ap.Record(out)
+	}
+
 	// start the balls rolling
-	openwhisk.Debug("OpenWhisk ActionLoop Proxy %s: starting", openwhisk.Version)
+	openwhisk.NewLogger("proxy").Infof("OpenWhisk ActionLoop Proxy %s: starting", openwhisk.Version)
 	ap.Start(8080)
 
 }
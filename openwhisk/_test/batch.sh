@@ -0,0 +1,33 @@
+#!/bin/bash
+#
+# Licensed to the Apache Software Foundation (ASF) under one or more
+# contributor license agreements.  See the NOTICE file distributed with
+# this work for additional information regarding copyright ownership.
+# The ASF licenses this file to You under the Apache License, Version 2.0
+# (the "License"); you may not use this file except in compliance with
+# the License.  You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# a preloaded model supporting batches: answers an array of requests
+# with an array of answers, each one telling the size of the batch
+while read line
+do
+  if [ "${line:0:1}" == "[" ]
+  then
+    n=$(echo "$line" | grep -o '"action_name"' | wc -l)
+    out=""
+    for i in $(seq $n)
+    do out="$out{\"batch\": $n},"
+    done
+    echo "[${out%,}]" >&3
+  else
+    echo '{"batch": 1}' >&3
+  fi
+done
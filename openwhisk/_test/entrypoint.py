@@ -0,0 +1,29 @@
+#!/usr/bin/env python3
+#
+# Licensed to the Apache Software Foundation (ASF) under one or more
+# contributor license agreements.  See the NOTICE file distributed with
+# this work for additional information regarding copyright ownership.
+# The ASF licenses this file to You under the Apache License, Version 2.0
+# (the "License"); you may not use this file except in compliance with
+# the License.  You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+# a fake preloaded model run by the zygote in the forked interpreter:
+# it answers for each line on file descriptor 3 its pid, its arguments
+# and the command line of its process
+import json
+import os
+import sys
+
+out = os.fdopen(3, "w")
+for line in iter(sys.stdin.readline, ""):
+    with open("/proc/self/cmdline") as f:
+        cmdline = f.read().rstrip("\0").split("\0")
+    out.write(json.dumps({"model": "fake", "pid": os.getpid(), "argv": sys.argv, "cmdline": cmdline}) + "\n")
+    out.flush()
@@ -0,0 +1,35 @@
+#!/bin/bash
+#
+# Licensed to the Apache Software Foundation (ASF) under one or more
+# contributor license agreements.  See the NOTICE file distributed with
+# this work for additional information regarding copyright ownership.
+# The ASF licenses this file to You under the Apache License, Version 2.0
+# (the "License"); you may not use this file except in compliance with
+# the License.  You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# a fake preloaded model speaking protocol version 2 when requested:
+# it sends a stray answer before each answer
+if test "$__OW_PROTOCOL" == "2"
+then
+  echo '{"ok": true, "protocol": 2}' >&3
+  while read id len
+  do
+    read -r -N $((len+1)) payload
+    out='{"model": "framed"}'
+    printf '%d %d\n%s\n' 0 2 '{}' >&3
+    printf '%d %d\n%s\n' "$id" ${#out} "$out" >&3
+  done
+else
+  while read line
+  do
+    echo '{"model": "fake"}' >&3
+  done
+fi
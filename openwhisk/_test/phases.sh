@@ -0,0 +1,39 @@
+#!/bin/bash
+#
+# Licensed to the Apache Software Foundation (ASF) under one or more
+# contributor license agreements.  See the NOTICE file distributed with
+# this work for additional information regarding copyright ownership.
+# The ASF licenses this file to You under the Apache License, Version 2.0
+# (the "License"); you may not use this file except in compliance with
+# the License.  You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# a fake model reporting its phases: import and load before the acknowledgement,
+# then infer before each answer. Run with "cold" it answers a single request
+# without acknowledging, reporting all the phases before the answer.
+phase() {
+  start=$(date +%s.%N)
+  sleep "$2"
+  echo "XXX_OW_PHASE_XXX {\"phase\": \"$1\", \"start\": $start, \"end\": $(date +%s.%N)}" >&3
+}
+phase import 0.05
+phase load 0.1
+if test "$1" = "cold"
+then
+  phase infer 0.02
+  echo '{"model": "phased"}' >&3
+  exit 0
+fi
+echo '{"ok": true}' >&3
+while read line
+do
+  phase infer 0.02
+  echo '{"model": "phased"}' >&3
+done
@@ -0,0 +1,61 @@
+#!/usr/bin/env python3
+#
+# Licensed to the Apache Software Foundation (ASF) under one or more
+# contributor license agreements.  See the NOTICE file distributed with
+# this work for additional information regarding copyright ownership.
+# The ASF licenses this file to You under the Apache License, Version 2.0
+# (the "License"); you may not use this file except in compliance with
+# the License.  You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# a stem cell specialized running the entrypoint of the model in its interpreter,
+# or executing the load command of the model without one: a real one would
+# import the shared libraries before acknowledging
+import json
+import os
+import runpy
+import sys
+import traceback
+
+os.write(3, b'{"ok": true}\n')
+
+# unbuffered, as the requests following the specialization are for the model
+line = b""
+while not line.endswith(b"\n"):
+    c = os.read(0, 1)
+    if not c:
+        os._exit(0)
+    line += c
+model = json.loads(line)["specialize"]
+if model.get("dir"):
+    os.chdir(model["dir"])
+if not model.get("entrypoint"):
+    os.execve(model["load"], [model["load"]] + model.get("args", []), model["env"])
+
+entrypoint = model["entrypoint"]
+os.environ.clear()
+os.environ.update(model["env"])
+sys.argv = [entrypoint] + model.get("args", [])
+sys.path[0] = os.path.dirname(os.path.abspath(entrypoint))
+code = 0
+try:
+    runpy.run_path(entrypoint, run_name="__main__")
+except SystemExit as e:
+    if isinstance(e.code, int):
+        code = e.code
+    elif e.code is not None:
+        print(e.code, file=sys.stderr)
+        code = 1
+except Exception:
+    traceback.print_exc()
+    code = 1
+sys.stdout.flush()
+sys.stderr.flush()
+os._exit(code)
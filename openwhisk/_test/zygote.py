@@ -0,0 +1,141 @@
+#!/usr/bin/env python3
+#
+# Licensed to the Apache Software Foundation (ASF) under one or more
+# contributor license agreements.  See the NOTICE file distributed with
+# this work for additional information regarding copyright ownership.
+# The ASF licenses this file to You under the Apache License, Version 2.0
+# (the "License"); you may not use this file except in compliance with
+# the License.  You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# a zygote forking the processes of the models: a real one would import
+# the shared libraries before acknowledging; the commands with an entrypoint
+# run it in the forked interpreter, the others are executed
+import json
+import os
+import resource
+import runpy
+import select
+import signal
+import socket
+import sys
+import traceback
+
+sock = socket.socket(fileno=3)
+wakeup, notify = os.pipe()
+os.set_blocking(notify, False)
+signal.set_wakeup_fd(notify)
+signal.signal(signal.SIGCHLD, lambda signum, frame: None)
+
+
+def send(msg):
+    sock.sendall((json.dumps(msg) + "\n").encode())
+
+
+def reap():
+    while True:
+        try:
+            pid, status = os.waitpid(-1, os.WNOHANG)
+        except ChildProcessError:
+            return
+        if pid == 0:
+            return
+        if os.WIFSIGNALED(status):
+            send({"exited": pid, "signal": os.WTERMSIG(status)})
+        else:
+            send({"exited": pid, "code": os.WEXITSTATUS(status)})
+
+
+def limit(limits):
+    try:
+        if limits.get("cgroup"):
+            with open(os.path.join(limits["cgroup"], "cgroup.procs"), "w") as f:
+                f.write("0")
+        if limits.get("as"):
+            resource.setrlimit(resource.RLIMIT_AS, (limits["as"], limits["as"]))
+        if limits.get("nproc"):
+            resource.setrlimit(resource.RLIMIT_NPROC, (limits["nproc"], limits["nproc"]))
+    except OSError as e:
+        print("zygote: cannot limit: %s" % e, file=sys.stderr)
+
+
+def run(entrypoint, args, env):
+    os.environ.clear()
+    os.environ.update(env)
+    sys.argv = [entrypoint] + args[1:]
+    sys.path[0] = os.path.dirname(os.path.abspath(entrypoint))
+    code = 0
+    try:
+        runpy.run_path(entrypoint, run_name="__main__")
+    except SystemExit as e:
+        if isinstance(e.code, int):
+            code = e.code
+        elif e.code is not None:
+            print(e.code, file=sys.stderr)
+            code = 1
+    except Exception:
+        traceback.print_exc()
+        code = 1
+    sys.stdout.flush()
+    sys.stderr.flush()
+    os._exit(code)
+
+
+def spawn(req, fds):
+    pid = os.fork()
+    if pid > 0:
+        return pid
+    try:
+        signal.set_wakeup_fd(-1)
+        signal.signal(signal.SIGCHLD, signal.SIG_DFL)
+        if req.get("group"):
+            os.setpgid(0, 0)
+        for target, fd in enumerate(fds):
+            os.dup2(fd, target)
+        limit(req.get("limits", {}))
+        sock.detach()
+        os.closerange(len(fds), 1024)
+        if req.get("dir"):
+            os.chdir(req["dir"])
+        env = dict(e.split("=", 1) for e in req["env"])
+        if req.get("entrypoint"):
+            run(req["entrypoint"], req["args"], env)
+        os.execve(req["path"], req["args"], env)
+    except Exception as e:
+        os.write(2, ("zygote: %s\n" % e).encode())
+    os._exit(127)
+
+
+send({"ok": True})
+buf = b""
+pending = []
+while True:
+    ready, _, _ = select.select([sock, wakeup], [], [])
+    if wakeup in ready:
+        os.read(wakeup, 1024)
+        reap()
+    if sock not in ready:
+        continue
+    data, fds, _, _ = socket.recv_fds(sock, 65536, 16)
+    if not data:
+        sys.exit(0)
+    buf += data
+    pending += fds
+    while b"\n" in buf:
+        line, buf = buf.split(b"\n", 1)
+        req = json.loads(line)
+        fds, pending = pending[:4], pending[4:]
+        try:
+            send({"pid": spawn(req, fds)})
+        except OSError as e:
+            send({"error": str(e)})
+        for fd in fds:
+            os.close(fd)
+    reap()
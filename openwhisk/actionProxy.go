@@ -22,16 +22,24 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // ActionProxy is the container of the data specific to a server
 type ActionProxy struct {
 
+	// mutex is held for writing by /init and /clean,
+	// and for reading by the other requests
+	mutex sync.RWMutex
+
+	// executorMutex protects theExecutor while requests are served
+	executorMutex sync.Mutex
+
 	// is it initialized?
 	initialized bool
 
@@ -47,25 +55,26 @@ type ActionProxy struct {
 	// theChannel is the channel communicating with the action
 	theExecutor *Executor
 
-	// theChannel is the channel communicating with the action
+	// models is the registry of the models that can be preloaded
+	models *modelRegistry
+
+	// memory the preloaded models can use, in bytes, 0 if unlimited
+	memoryBudget uint64
+	// samplingRSS starts sampling the memory of the models once, with a budget
+	samplingRSS sync.Once
+	// budgetMutex serializes making room in the budget
+	budgetMutex sync.Mutex
+	// memory reserved for the replicas and the cold runs being started, in bytes
+	reservedMemory uint64
+
+	// how many requests can wait for a busy model, 0 if unlimited
+	queueDepth int
 
-	thealexExecutor      *alexExecutor
-	thevggExecutor       *vggExecutor
-	theinceptionExecutor *inceptionExecutor
-	theresnet18Executor  *resnet18Executor
-	theresnet50Executor  *resnet50Executor
-	theresnet152Executor *resnet152Executor
-	thegooglenetExecutor *googlenetExecutor
-	thebertExecutor      *bertExecutor
-
-	theOriginalexExecutor      *OriginalexExecutor
-	theOriginvggExecutor       *OriginvggExecutor
-	theOrigininceptionExecutor *OrigininceptionExecutor
-	theOriginresnet18Executor  *Originresnet18Executor
-	theOriginresnet50Executor  *Originresnet50Executor
-	theOriginresnet152Executor *Originresnet152Executor
-	theOrigingooglenetExecutor *OrigingooglenetExecutor
-	theOriginbertExecutor      *OriginbertExecutor
+	// zygote forks the processes of the models, nil if none
+	zygote *zygote
+
+	// stemCells are specialized in the models, nil if none
+	stemCells *stemPool
 
 	// out and err files
 	outFile *os.File
@@ -73,36 +82,34 @@ type ActionProxy struct {
 
 	// environment
 	env map[string]string
+
+	// recorder records the requests served, nil if not recording
+	recorder *recorder
 }
 
 // NewActionProxy creates a new action proxy that can handle http requests
 func NewActionProxy(baseDir string, compiler string, outFile *os.File, errFile *os.File) *ActionProxy {
 	os.Mkdir(baseDir, 0755)
 	return &ActionProxy{
+		sync.RWMutex{},
+		sync.Mutex{},
 		false,
 		baseDir,
 		compiler,
 		highestDir(baseDir),
 		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
-		nil,
+		newModelRegistry(DefaultModels, outFile, errFile),
+		0,
+		sync.Once{},
+		sync.Mutex{},
+		0,
+		0,
 		nil,
 		nil,
 		outFile,
 		errFile,
 		map[string]string{},
+		nil,
 	}
 }
 
@@ -120,6 +127,11 @@ func (ap *ActionProxy) SetEnv(env map[string]interface{}) {
 	if wa != "" {
 		ap.env["__OW_WAIT_FOR_ACK"] = wa
 	}
+	// request a version of the protocol
+	pv := os.Getenv("OW_PROTOCOL")
+	if pv != "" {
+		ap.env[ProtocolEnv] = pv
+	}
 	// propagate all the variables starting with "__OW_"
 	for _, v := range os.Environ() {
 		if strings.HasPrefix(v, "__OW_") {
@@ -139,13 +151,13 @@ func (ap *ActionProxy) SetEnv(env map[string]interface{}) {
 			ap.env[k] = string(buf)
 		}
 	}
-	Debug("init env: %s", ap.env)
+	proxyLog.Debugf("init env: %s", envNames(ap.env))
 }
 
 // Unset user environment
 func (ap *ActionProxy) UnsetEnv() {
 	ap.env = map[string]string{}
-	Debug("clean env: %s", ap.env)
+	proxyLog.Debugf("clean env")
 }
 
 // StartLatestAction tries to start
@@ -158,7 +170,7 @@ func (ap *ActionProxy) StartLatestAction() error {
 	// find the action if any
 	highestDir := highestDir(ap.baseDir)
 	if highestDir == 0 {
-		Debug("no action found")
+		proxyLog.Warnf("no action found")
 		ap.theExecutor = nil
 		return fmt.Errorf("no valid actions available")
 	}
@@ -177,64 +189,8 @@ func (ap *ActionProxy) StartLatestAction() error {
 		}
 	}
 
-	//Create executor for each inference function：
-	NEWalexExecutor := NewalexExecutor(ap.outFile, ap.errFile, "_test/loadalex.sh", ap.env)
-	NEWvggExecutor := NewvggExecutor(ap.outFile, ap.errFile, "_test/loadvgg.sh", ap.env)
-	NEWinceptionExecutor := NewinceptionExecutor(ap.outFile, ap.errFile, "_test/loadinception.sh", ap.env)
-	NEWresnet18Executor := Newresnet18Executor(ap.outFile, ap.errFile, "_test/loadres18.sh", ap.env)
-	NEWresnet50Executor := Newresnet50Executor(ap.outFile, ap.errFile, "_test/loadres50.sh", ap.env)
-	NEWresnet152Executor := Newresnet152Executor(ap.outFile, ap.errFile, "_test/loadres152.sh", ap.env)
-	NEWgooglenetExecutor := NewgooglenetExecutor(ap.outFile, ap.errFile, "_test/loadgooglenet.sh", ap.env)
-	NEWbertExecutor := NewbertExecutor(ap.outFile, ap.errFile, "_test/loadbert.sh", ap.env)
-
-	if ap.thealexExecutor == nil {
-		ap.thealexExecutor = NEWalexExecutor
-	}
-	if ap.thevggExecutor == nil {
-		ap.thevggExecutor = NEWvggExecutor
-	}
-	if ap.theinceptionExecutor == nil {
-		ap.theinceptionExecutor = NEWinceptionExecutor
-	}
-	if ap.theresnet18Executor == nil {
-		ap.theresnet18Executor = NEWresnet18Executor
-	}
-	if ap.theresnet50Executor == nil {
-		ap.theresnet50Executor = NEWresnet50Executor
-	}
-	if ap.theresnet152Executor == nil {
-		ap.theresnet152Executor = NEWresnet152Executor
-	}
-	if ap.thegooglenetExecutor == nil {
-		ap.thegooglenetExecutor = NEWgooglenetExecutor
-	}
-	if ap.thebertExecutor == nil {
-		ap.thebertExecutor = NEWbertExecutor
-	}
-
-	NEWOriginalexExecutor := NewOriginalexExecutor(ap.outFile, ap.errFile, "_test/funcalex.sh", ap.env)
-	ap.theOriginalexExecutor = NEWOriginalexExecutor
-
-	NEWOriginvggExecutor := NewOriginvggExecutor(ap.outFile, ap.errFile, "_test/funcvgg.sh", ap.env)
-	ap.theOriginvggExecutor = NEWOriginvggExecutor
-
-	NEWOrigininceptionExecutor := NewOrigininceptionExecutor(ap.outFile, ap.errFile, "_test/funcinception.sh", ap.env)
-	ap.theOrigininceptionExecutor = NEWOrigininceptionExecutor
-
-	NEWOriginresnet18Executor := NewOriginresnet18Executor(ap.outFile, ap.errFile, "_test/func18.sh", ap.env)
-	ap.theOriginresnet18Executor = NEWOriginresnet18Executor
-
-	NEWOriginresnet50Executor := NewOriginresnet50Executor(ap.outFile, ap.errFile, "_test/func50.sh", ap.env)
-	ap.theOriginresnet50Executor = NEWOriginresnet50Executor
-
-	NEWOriginresnet152Executor := NewOriginresnet152Executor(ap.outFile, ap.errFile, "_test/func152.sh", ap.env)
-	ap.theOriginresnet152Executor = NEWOriginresnet152Executor
-
-	NEWOrigingooglenetExecutor := NewOrigingooglenetExecutor(ap.outFile, ap.errFile, "_test/funcgooglenet.sh", ap.env)
-	ap.theOrigingooglenetExecutor = NEWOrigingooglenetExecutor
-
-	NEWOriginbertExecutor := NewOriginbertExecutor(ap.outFile, ap.errFile, "_test/funcbert.sh", ap.env)
-	ap.theOriginbertExecutor = NEWOriginbertExecutor
+	// create the executors for each model
+	ap.models.prepare(ap.env)
 
 	// save the current executor  将ActionProxy结构体中的成员theExecutor的值赋给curExecutor
 	curExecutor := ap.theExecutor
@@ -245,7 +201,7 @@ func (ap *ActionProxy) StartLatestAction() error {
 	os.Chmod(executable, 0755) //改变executable文件的权限为0755
 	//生成一个新Executor，并将其赋给newExecutor
 	newExecutor := NewExecutor(ap.outFile, ap.errFile, executable, ap.env)
-	Debug("starting %s", executable)
+	proxyLog.Debugf("starting %s", executable)
 
 	// start executor 这是唯一使用到executor.Start()的地方
 	//executor.Start()没有将cmd作为input，而是直接读取executor类中的cmd：
@@ -255,7 +211,7 @@ func (ap *ActionProxy) StartLatestAction() error {
 	if err == nil {
 		ap.theExecutor = newExecutor
 		if curExecutor != nil {
-			Debug("stopping old executor")
+			proxyLog.Debugf("stopping old executor")
 			curExecutor.Stop()
 		}
 		return nil
@@ -265,31 +221,77 @@ func (ap *ActionProxy) StartLatestAction() error {
 	// and leaving the current executor running
 	if !Debugging {
 		exeDir := fmt.Sprintf("./action/%d/", highestDir)
-		Debug("removing the failed action in %s", exeDir)
+		proxyLog.Warnf("removing the failed action in %s", exeDir)
 		os.RemoveAll(exeDir)
 	}
 	return err
 }
 
+// RequestIDHeader identifies a request in the log lines,
+// the proxy numbers the requests without it
+const RequestIDHeader = "X-Request-Id"
+
+// lastRequestID numbers the requests without an id
+var lastRequestID uint64
+
 //这里用来处理ContainerProxy.scala发来的signal
 func (ap *ActionProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// a /run request is received now, the time waiting for the locks counts
+	if r.URL.Path == "/run" {
+		r = r.WithContext(withTiming(r.Context(), newRunTiming()))
+	}
+	ap.recorder.record(w, r, ap.serve)
+}
+
+// serve dispatches the request to its handler
+func (ap *ActionProxy) serve(w http.ResponseWriter, r *http.Request) {
+	// the lines about the request carry its id
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" {
+		id = fmt.Sprintf("%d", atomic.AddUint64(&lastRequestID, 1))
+	}
+	log := httpLog.With("request", id)
+	r = r.WithContext(withLogger(r.Context(), log))
+	log.Debugf("%s %s", r.Method, r.URL.Path)
+
 	switch r.URL.Path {
 	case "/init":
-		Debug("Proxy Receive an init Signal")
+		ap.mutex.Lock()
+		defer ap.mutex.Unlock()
 		ap.initHandler(w, r)
 	case "/load":
-		Debug("Proxy Receive a load Signal")
-		if !ap.HasAnyExecutorStarted() {
-			ap.loadHandler(w, r)
+		ap.mutex.RLock()
+		defer ap.mutex.RUnlock()
+		if ap.HasAnyExecutorStarted() {
+			sendError(w, http.StatusServiceUnavailable, "a cold run is in progress, retry the load once it completes")
+			return
 		}
+		ap.loadHandler(w, r)
 	case "/offload":
-		Debug("Proxy Receive an offload Signal")
+		ap.mutex.RLock()
+		defer ap.mutex.RUnlock()
 		ap.offloadHandler(w, r)
+	case "/scale":
+		ap.mutex.RLock()
+		defer ap.mutex.RUnlock()
+		ap.scaleHandler(w, r)
 	case "/run":
-		Debug("Proxy Receive a run Signal")
-		ap.loadRunHandler(w, r)
+		ap.mutex.RLock()
+		defer ap.mutex.RUnlock()
+		timeRun(w, r, ap.loadRunHandler)
+	case "/status":
+		ap.mutex.RLock()
+		defer ap.mutex.RUnlock()
+		ap.statusHandler(w, r)
+	case "/metrics":
+		ap.mutex.RLock()
+		defer ap.mutex.RUnlock()
+		ap.metricsHandler(w, r)
+	case "/log":
+		ap.logHandler(w, r)
 	case "/clean":
-		Debug("Proxy Receive a clean Signal")
+		ap.mutex.Lock()
+		defer ap.mutex.Unlock()
 		ap.cleanHandler(w, r)
 	}
 }
@@ -298,7 +300,10 @@ func (ap *ActionProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (ap *ActionProxy) Start(port int) {
 	// listen and start
 	//启动一个 HTTP 服务器，该服务器监听在指定的端口，并使用 ActionProxy 作为处理器
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), ap))
+	proxyLog.Infof("listening on port %d", port)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", port), ap)
+	proxyLog.Errorf("cannot serve: %v", err)
+	os.Exit(1)
 }
 
 // ExtractAndCompileIO read in input and write in output to use the runtime as a compiler "on-the-fly"
@@ -307,7 +312,8 @@ func (ap *ActionProxy) ExtractAndCompileIO(r io.Reader, w io.Writer, main string
 	// read the std input
 	in, err := ioutil.ReadAll(r) //从输入流 r 中读取所有数据，将数据赋给 in，如果读取过程中出现错误，该错误被赋值给 err
 	if err != nil {
-		log.Fatal(err)
+		compilerLog.Errorf("%v", err)
+		os.Exit(1)
 	}
 
 	envMap := make(map[string]interface{})
@@ -321,89 +327,68 @@ func (ap *ActionProxy) ExtractAndCompileIO(r io.Reader, w io.Writer, main string
 	//这意味着 ExtractAndCompileIO 可以直接从输入流中读取数据并向输出流写入数据， 而 ExtractAndCompile 则需要提前得到字节切片。
 	file, err := ap.ExtractAndCompile(&in, main) //提取和编译输入内容，编译后的文件路径被赋值给 file
 	if err != nil {
-		log.Fatal(err)
+		compilerLog.Errorf("%v", err)
+		os.Exit(1)
 	}
 
 	// zip the directory containing the file and write output
 	zip, err := Zip(filepath.Dir(file))
 	if err != nil {
-		log.Fatal(err)
+		compilerLog.Errorf("%v", err)
+		os.Exit(1)
 	}
 
 	_, err = w.Write(zip)
 	if err != nil {
-		log.Fatal(err)
+		compilerLog.Errorf("%v", err)
+		os.Exit(1)
 	}
 }
 
+// StopAllExecutorsExcept stops every preloaded model but the named one.
+// The caller must not hold the mutex of any model.
 func (ap *ActionProxy) StopAllExecutorsExcept(name string) {
-	if name != "alex" && ap.thealexExecutor != nil && ap.thealexExecutor.started {
-		ap.thealexExecutor.Stop()
-		ap.thealexExecutor = nil
-		Debug("ap stopped alex")
-	}
-	if name != "vgg" && ap.thevggExecutor != nil && ap.thevggExecutor.started {
-		ap.thevggExecutor.Stop()
-		ap.thevggExecutor = nil
-		Debug("ap stopped vgg")
-	}
-	if name != "inception" && ap.theinceptionExecutor != nil && ap.theinceptionExecutor.started {
-		ap.theinceptionExecutor.Stop()
-		ap.theinceptionExecutor = nil
-		Debug("ap stopped inception")
-	}
-	if name != "resnet18" && ap.theresnet18Executor != nil && ap.theresnet18Executor.started {
-		ap.theresnet18Executor.Stop()
-		ap.theresnet18Executor = nil
-		Debug("ap stopped resnet18")
-	}
-	if name != "resnet50" && ap.theresnet50Executor != nil && ap.theresnet50Executor.started {
-		ap.theresnet50Executor.Stop()
-		ap.theresnet50Executor = nil
-		Debug("ap stopped resnet50")
-	}
-	if name != "resnet152" && ap.theresnet152Executor != nil && ap.theresnet152Executor.started {
-		ap.theresnet152Executor.Stop()
-		ap.theresnet152Executor = nil
-		Debug("ap stopped resnet152")
-	}
-	if name != "googlenet" && ap.thegooglenetExecutor != nil && ap.thegooglenetExecutor.started {
-		ap.thegooglenetExecutor.Stop()
-		ap.thegooglenetExecutor = nil
-		Debug("ap stopped googlenet")
-	}
-	if name != "bert" && ap.thebertExecutor != nil && ap.thebertExecutor.started {
-		ap.thebertExecutor.Stop()
-		ap.thebertExecutor = nil
-		Debug("ap stopped bert")
+	for _, m := range ap.models.all() {
+		if m.Name != name {
+			m.mutex.RLock()
+			started := len(m.replicas) > 0
+			m.mutex.RUnlock()
+			if started {
+				m.stop()
+				modelLog.With("model", m.Name).Debugf("stopped")
+			}
+		}
 	}
 }
 
 //在load前，检查proxy中是否正在执行OriginExecutor（non-loaded function)
 func (ap *ActionProxy) HasAnyExecutorStarted() bool {
-	if ap.theOriginalexExecutor != nil && ap.theOriginalexExecutor.started {
-		return true
-	}
-	if ap.theOriginvggExecutor != nil && ap.theOriginvggExecutor.started {
-		return true
-	}
-	if ap.theOrigininceptionExecutor != nil && ap.theOrigininceptionExecutor.started {
-		return true
-	}
-	if ap.theOriginresnet18Executor != nil && ap.theOriginresnet18Executor.started {
-		return true
-	}
-	if ap.theOriginresnet50Executor != nil && ap.theOriginresnet50Executor.started {
-		return true
-	}
-	if ap.theOriginresnet152Executor != nil && ap.theOriginresnet152Executor.started {
-		return true
-	}
-	if ap.theOrigingooglenetExecutor != nil && ap.theOrigingooglenetExecutor.started {
-		return true
-	}
-	if ap.theOriginbertExecutor != nil && ap.theOriginbertExecutor.started {
-		return true
+	for _, m := range ap.models.all() {
+		if atomic.LoadInt32(&m.coldRunning) > 0 {
+			return true
+		}
 	}
 	return false
 }
+
+// SetQueueDepth sets how many requests can wait for a busy model,
+// further requests are refused. 0 means unlimited.
+func (ap *ActionProxy) SetQueueDepth(depth int) {
+	ap.queueDepth = depth
+}
+
+// getExecutor returns the executor of the action
+func (ap *ActionProxy) getExecutor() *Executor {
+	ap.executorMutex.Lock()
+	defer ap.executorMutex.Unlock()
+	return ap.theExecutor
+}
+
+// dropExecutor forgets the executor of the action, if it is still the given one
+func (ap *ActionProxy) dropExecutor(executor *Executor) {
+	ap.executorMutex.Lock()
+	defer ap.executorMutex.Unlock()
+	if ap.theExecutor == executor {
+		ap.theExecutor = nil
+	}
+}
@@ -19,6 +19,7 @@ package openwhisk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
@@ -111,20 +112,21 @@ func TestStartLatestAction_emit2(t *testing.T) {
 	fmt.Println(string(actionName))
 
 	// load model
-	err1 := ap.theresnet50Executor.Start(false)
+	res50 := ap.models.get("resnet50").newExecutor(ap.env)
+	err1 := res50.Start(false, 0)
 	//res, _ := ap.theOriginresnet50Executor.StartAndWaitForOutput()
 
 	fmt.Println(string("Noerr:"))
 	//fmt.Println(err1.Error())
 	// check for early termination
 	if err1 != nil {
-		Debug("WARNING! Command exited")
+		modelLog.Warnf("command exited")
 		fmt.Println(string("err:"))
 		//ap.theresnet50Executor = nil
 		//return
 	}
 	time.Sleep(1 * time.Second)
-	res, _ := ap.theresnet50Executor.Interact([]byte(bodyBytes))
+	res, _ := res50.Interact(context.Background(), []byte(bodyBytes), 0)
 
 	fmt.Println(string("res:"))
 	fmt.Println(string(res))
@@ -204,7 +206,7 @@ func Example_badcompile() {
 
 }
 
-func Example_SetEnv() {
+func ExampleActionProxy_SetEnv() {
 	ap := NewActionProxy("", "", nil, nil)
 	fmt.Println(ap.env)
 	var m map[string]interface{}
@@ -0,0 +1,96 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// cpuMaskWords is the size of the cpu masks, enough for 1024 cpus
+const cpuMaskWords = 16
+
+// setAffinity restricts the process to the cpus of the set,
+// the processes and threads it creates afterwards inherit it
+func setAffinity(pid int, set cpuSet) error {
+	var mask [cpuMaskWords]uint64
+	for _, cpu := range set {
+		if cpu >= cpuMaskWords*64 {
+			return syscall.EINVAL
+		}
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// startWithAffinity starts the command restricted to the cpus of the set, if any,
+// so that the threads it creates before being limited are restricted too.
+// The child inherits the affinity of the thread forking it: the thread is set
+// for the start, then restored, or terminated if it cannot be restored.
+func startWithAffinity(cmd *exec.Cmd, set cpuSet) error {
+	if len(set) == 0 {
+		return cmd.Start()
+	}
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		saved, err := affinity(0)
+		if err == nil {
+			err = setAffinity(0, set)
+		}
+		if err != nil {
+			runtime.UnlockOSThread()
+			// limited after the start instead
+			done <- cmd.Start()
+			return
+		}
+		err = cmd.Start()
+		if setAffinity(0, saved) == nil {
+			runtime.UnlockOSThread()
+		}
+		done <- err
+	}()
+	return <-done
+}
+
+// affinity returns the cpus the process can run on, 0 for the proxy
+func affinity(pid int) (cpuSet, error) {
+	var mask [cpuMaskWords]uint64
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return nil, errno
+	}
+	set := cpuSet{}
+	for cpu := 0; cpu < cpuMaskWords*64; cpu++ {
+		if mask[cpu/64]&(1<<uint(cpu%64)) != 0 {
+			set = append(set, cpu)
+		}
+	}
+	return set, nil
+}
+
+// availableCPUs returns the cpus the proxy can run on
+func availableCPUs() (cpuSet, error) {
+	return affinity(0)
+}
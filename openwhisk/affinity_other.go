@@ -0,0 +1,51 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+)
+
+// setAffinity is not supported outside Linux
+func setAffinity(pid int, set cpuSet) error {
+	return errors.New("cpu affinity not supported")
+}
+
+// startWithAffinity starts the command, the cpus cannot be set outside Linux
+func startWithAffinity(cmd *exec.Cmd, set cpuSet) error {
+	return cmd.Start()
+}
+
+// affinity is not supported outside Linux
+func affinity(pid int) (cpuSet, error) {
+	return nil, errors.New("cpu affinity not supported")
+}
+
+// availableCPUs assumes the proxy can run on all the cpus
+func availableCPUs() (cpuSet, error) {
+	set := cpuSet{}
+	for cpu := 0; cpu < runtime.NumCPU(); cpu++ {
+		set = append(set, cpu)
+	}
+	return set, nil
+}
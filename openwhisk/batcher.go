@@ -0,0 +1,220 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// batchReply is the answer to a request collected in a batch
+type batchReply struct {
+	response []byte
+	err      error
+}
+
+// batchRequest is a request waiting in a batch
+type batchRequest struct {
+	// ctx is the one of the request, carrying its logger
+	ctx     context.Context
+	line    []byte
+	timeout time.Duration
+	// depth is how many requests can wait for the model, 0 for unlimited
+	depth int
+	reply chan batchReply
+}
+
+// batcher collects the requests to a model arriving within a window
+// and sends them to a replica as a single JSON array line
+type batcher struct {
+	model  *Model
+	window time.Duration
+	max    int
+
+	mutex   sync.Mutex
+	pending []*batchRequest
+	timer   *time.Timer
+	// generation identifies the batch being collected,
+	// so that the timer of a batch already sent does not send the next one
+	generation int
+}
+
+func newBatcher(m *Model, window time.Duration, max int) *batcher {
+	return &batcher{model: m, window: window, max: max}
+}
+
+// submit adds the request line to the batch being collected
+// and waits for its answer. The batch waits for the answer
+// up to the longest timeout of its requests, and for its turn
+// in the queue of the model, failing if depth requests already wait.
+// A request whose context is done is removed from the batch.
+func (b *batcher) submit(ctx context.Context, line []byte, timeout time.Duration, depth int) ([]byte, error) {
+	req := &batchRequest{ctx, line, timeout, depth, make(chan batchReply, 1)}
+	b.mutex.Lock()
+	b.pending = append(b.pending, req)
+	if len(b.pending) >= b.max {
+		go b.send(b.take())
+	} else if len(b.pending) == 1 {
+		generation := b.generation
+		b.timer = time.AfterFunc(b.window, func() { b.flush(generation) })
+	}
+	b.mutex.Unlock()
+
+	select {
+	case reply := <-req.reply:
+		return reply.response, reply.err
+	case <-ctx.Done():
+		b.remove(req)
+		return nil, ctx.Err()
+	}
+}
+
+// remove drops the request from the batch being collected, if still there
+func (b *batcher) remove(req *batchRequest) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i, pending := range b.pending {
+		if pending == req {
+			b.pending = append(b.pending[:i], b.pending[i+1:]...)
+			break
+		}
+	}
+	if len(b.pending) == 0 {
+		b.take()
+	}
+}
+
+// live returns the requests of the batch whose context is not done
+func live(batch []*batchRequest) []*batchRequest {
+	res := []*batchRequest{}
+	for _, req := range batch {
+		if req.ctx.Err() == nil {
+			res = append(res, req)
+		}
+	}
+	return res
+}
+
+// batchContext is done when the contexts of all the requests of the batch are
+func batchContext(batch []*batchRequest) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for _, req := range batch {
+			select {
+			case <-req.ctx.Done():
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// take removes the batch being collected and starts a new one,
+// the caller must hold the mutex
+func (b *batcher) take() []*batchRequest {
+	batch := b.pending
+	b.pending = nil
+	b.generation++
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+// flush sends the batch collected when the window expires, if not already sent
+func (b *batcher) flush(generation int) {
+	b.mutex.Lock()
+	if generation != b.generation {
+		b.mutex.Unlock()
+		return
+	}
+	batch := b.take()
+	b.mutex.Unlock()
+	b.send(batch)
+}
+
+// send serves the batch on a replica of the model, when it is its turn,
+// and splits the answer to the requests. The requests cancelled
+// while waiting are not sent.
+func (b *batcher) send(batch []*batchRequest) {
+	m := b.model
+	batch = live(batch)
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := batchContext(batch)
+	defer cancel()
+	if err := m.queue.enter(ctx, batch[0].depth); err != nil {
+		for _, req := range batch {
+			req.reply <- batchReply{nil, err}
+		}
+		return
+	}
+	defer m.queue.leave()
+	if batch = live(batch); len(batch) == 0 {
+		return
+	}
+
+	// a single request is sent as it is
+	if len(batch) == 1 {
+		response, err := m.serve(batch[0].ctx, batch[0].line, batch[0].timeout, 1)
+		batch[0].reply <- batchReply{response, err}
+		return
+	}
+
+	lines := make([][]byte, len(batch))
+	var timeout time.Duration
+	for i, req := range batch {
+		requestLog(req.ctx).Subsystem("model").With("model", m.Name).Debugf("sending in a batch of %d requests", len(batch))
+		timingOf(req.ctx).choose(PathWarm)
+		lines[i] = req.line
+		if req.timeout > timeout {
+			timeout = req.timeout
+		}
+	}
+	line := append(append([]byte("["), bytes.Join(lines, []byte(","))...), ']')
+	response, err := m.serve(context.Background(), line, timeout, len(batch))
+	for _, req := range batch {
+		timingOf(req.ctx).mark(PhaseFirstByte)
+	}
+	var responses []json.RawMessage
+	if err == nil {
+		response = bytes.ReplaceAll(response, []byte("'"), []byte("\""))
+		err = json.Unmarshal(response, &responses)
+		if err == nil && len(responses) != len(batch) {
+			err = fmt.Errorf("%d answers to a batch of %d requests", len(responses), len(batch))
+		}
+		if err != nil {
+			err = fmt.Errorf("bad answer to a batch: %v", err)
+		}
+	}
+	for i, req := range batch {
+		if err != nil {
+			req.reply <- batchReply{nil, err}
+		} else {
+			req.reply <- batchReply{responses[i], nil}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher_maxBatch(t *testing.T) {
+	ap := NewActionProxy("./action/bt", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "batch", Action: "/guest/batch", Load: "_test/batch.sh", Cold: "_test/cold.sh",
+			Batch: true, BatchWindowMS: 2000, MaxBatch: 4},
+		{Name: "single", Action: "/guest/single", Load: "_test/batch.sh", Cold: "_test/cold.sh",
+			BatchWindowMS: 2000, MaxBatch: 4},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+	ap.SetMemoryBudget(1024)
+
+	runs := func(action string) []string {
+		_, status, _ := doPost(ts.URL+"/load", `{"action_name":"`+action+`"}`)
+		assert.Equal(t, http.StatusOK, status)
+		var wg sync.WaitGroup
+		res := make([]string, 4)
+		for i := range res {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				out, status, _ := doPost(ts.URL+"/run", `{"action_name":"`+action+`","value":{}}`)
+				assert.Equal(t, http.StatusOK, status)
+				res[i] = strings.TrimSpace(out)
+			}(i)
+		}
+		wg.Wait()
+		return res
+	}
+
+	// the batch is sent as soon as it is full, without waiting for the window
+	for _, res := range runs("/guest/batch") {
+		assert.Equal(t, `{"batch": 4}`, res)
+	}
+	// without batch support the requests are sent one by one
+	for _, res := range runs("/guest/single") {
+		assert.Equal(t, `{"batch": 1}`, res)
+	}
+}
+
+func TestBatcher_window(t *testing.T) {
+	m := newModel(ModelSpec{Name: "batch", Load: "_test/batch.sh", Batch: true, BatchWindowMS: 50, MaxBatch: 8})
+	assert.NotNil(t, m.batcher)
+	assert.Nil(t, m.scale(map[string]string{}, 1))
+	defer m.stop()
+
+	// a lone request is sent when the window expires
+	res, err := m.batcher.submit(context.Background(), []byte(`{"action_name":"a"}`), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"batch": 1}`, string(res))
+
+	m.stop()
+	_, err = m.batcher.submit(context.Background(), []byte(`{"action_name":"a"}`), 0, 0)
+	assert.Equal(t, errNotLoaded, err)
+}
+
+func TestBatcher_cancelled(t *testing.T) {
+	m := newModel(ModelSpec{Name: "batch", Load: "_test/batch.sh", Batch: true, BatchWindowMS: 100, MaxBatch: 8})
+	assert.Nil(t, m.scale(map[string]string{}, 1))
+	defer m.stop()
+
+	// a cancelled request leaves the batch being collected
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, err := m.batcher.submit(ctx, []byte(`{"action_name":"a"}`), 0, 0)
+		cancelled <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-cancelled)
+	m.batcher.mutex.Lock()
+	assert.Empty(t, m.batcher.pending)
+	m.batcher.mutex.Unlock()
+
+	// and is not sent with the others
+	ctx, cancel = context.WithCancel(context.Background())
+	req := &batchRequest{ctx, []byte(`{"action_name":"a"}`), 0, 0, make(chan batchReply, 1)}
+	other := &batchRequest{context.Background(), []byte(`{"action_name":"b"}`), 0, 0, make(chan batchReply, 1)}
+	cancel()
+	m.batcher.send([]*batchRequest{req, other})
+	reply := <-other.reply
+	assert.Nil(t, reply.err)
+	assert.Equal(t, `{"batch": 1}`, string(reply.response))
+	assert.Empty(t, req.reply)
+}
+
+func TestBatcher_queueDepth(t *testing.T) {
+	m := newModel(ModelSpec{Name: "batch", Load: "_test/batch.sh", Batch: true, BatchWindowMS: 10, MaxBatch: 8})
+	assert.Nil(t, m.scale(map[string]string{}, 1))
+	defer m.stop()
+
+	// the batch waits in the queue like the single requests
+	assert.Nil(t, m.queue.enter(context.Background(), 0))
+	waiting := make(chan error)
+	go func() {
+		waiting <- m.queue.enter(context.Background(), 0)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_, err := m.batcher.submit(context.Background(), []byte(`{"action_name":"a"}`), 0, 1)
+	assert.Equal(t, ErrQueueFull, err)
+	m.queue.leave()
+	assert.Nil(t, <-waiting)
+	m.queue.leave()
+}
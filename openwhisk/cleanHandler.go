@@ -18,7 +18,6 @@
 package openwhisk
 
 import (
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -31,7 +30,7 @@ func (ap *ActionProxy) cleanHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		msg := "cannot remove action: " + err.Error()
         sendError(w, http.StatusBadRequest, msg)
-		log.Println(msg)
+		requestLog(r.Context()).Errorf("%s", msg)
 		return
     }
 
@@ -27,7 +27,7 @@ import (
 
 // check if the file exists and it is already compiled
 func isCompiled(file string) bool {
-	Debug("IsCompiled? %s", file)
+	compilerLog.Debugf("IsCompiled? %s", file)
 	_, err := os.Stat(file)
 	if err != nil {
 		return false
@@ -35,7 +35,7 @@ func isCompiled(file string) bool {
 
 	buf, err := ioutil.ReadFile(file)
 	if err != nil {
-		Debug(err.Error())
+		compilerLog.Debugf("cannot read %s: %v", file, err)
 		return false
 	}
 
@@ -49,7 +49,7 @@ func (ap *ActionProxy) CompileAction(main string, srcDir string, binDir string)
 		return fmt.Errorf("No compiler defined")
 	}
 
-	Debug("compiling: %s %s %s %s", ap.compiler, main, srcDir, binDir)
+	compilerLog.Debugf("compiling: %s %s %s %s", ap.compiler, main, srcDir, binDir)
 
 	var cmd *exec.Cmd
 	cmd = exec.Command(ap.compiler, main, srcDir, binDir)
@@ -60,7 +60,7 @@ func (ap *ActionProxy) CompileAction(main string, srcDir string, binDir string)
 
 	// gather stdout and stderr
 	out, err := cmd.CombinedOutput()
-	Debug("compiler out: %s, %v", out, err)
+	compilerLog.Debugf("compiler out: %s, %v", out, err)
 	if len(out) > 0 {
 		return fmt.Errorf("%s", out)
 	}
@@ -0,0 +1,133 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// threadEnvs are the variables setting the threads of the numeric libraries
+var threadEnvs = []string{
+	"OMP_NUM_THREADS",
+	"MKL_NUM_THREADS",
+	"OPENBLAS_NUM_THREADS",
+	"NUMEXPR_NUM_THREADS",
+	"VECLIB_MAXIMUM_THREADS",
+}
+
+// cpuSet is a sorted set of cpu numbers
+type cpuSet []int
+
+// parseCPUSet parses a list of cpus and ranges of cpus, like 0-3,6
+func parseCPUSet(s string) (cpuSet, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("bad cpuset %q", s)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("bad cpuset %q", s)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+	set := cpuSet{}
+	for cpu := range seen {
+		set = append(set, cpu)
+	}
+	sort.Ints(set)
+	return set, nil
+}
+
+// String formats the set as a list of cpus and ranges of cpus
+func (set cpuSet) String() string {
+	parts := []string{}
+	for i := 0; i < len(set); {
+		j := i
+		for j+1 < len(set) && set[j+1] == set[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(set[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", set[i], set[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// missing returns the cpus of the set not in the other one
+func (set cpuSet) missing(other cpuSet) cpuSet {
+	in := map[int]bool{}
+	for _, cpu := range other {
+		in[cpu] = true
+	}
+	res := cpuSet{}
+	for _, cpu := range set {
+		if !in[cpu] {
+			res = append(res, cpu)
+		}
+	}
+	return res
+}
+
+// threads is how many threads the numeric libraries of the model use:
+// the declared ones, or one for each cpu of its cpuset, 0 if not set
+func (spec *ModelSpec) threads() int {
+	if spec.Threads > 0 {
+		return spec.Threads
+	}
+	if set, err := parseCPUSet(spec.CPUSet); err == nil && spec.CPUSet != "" {
+		return len(set)
+	}
+	return 0
+}
+
+// checkCPUs validates the cpuset and the threads of the model against the available cpus
+func (spec *ModelSpec) checkCPUs() error {
+	if spec.Threads < 0 {
+		return fmt.Errorf("negative threads")
+	}
+	if spec.CPUSet == "" {
+		return nil
+	}
+	set, err := parseCPUSet(spec.CPUSet)
+	if err != nil {
+		return err
+	}
+	available, err := availableCPUs()
+	if err != nil {
+		return fmt.Errorf("cannot read the available cpus: %v", err)
+	}
+	if missing := set.missing(available); len(missing) > 0 {
+		return fmt.Errorf("cpus %s not available, only %s are", missing, available)
+	}
+	return nil
+}
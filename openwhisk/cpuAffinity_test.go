@@ -0,0 +1,99 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Example_parseCPUSet() {
+	for _, s := range []string{"0-3,6, 5 ,2", "7", "3-1", "a", ""} {
+		set, err := parseCPUSet(s)
+		fmt.Println(set, err)
+	}
+	// Output:
+	// 0-3,5-6 <nil>
+	// 7 <nil>
+	//  bad cpuset "3-1"
+	//  bad cpuset "a"
+	//  bad cpuset ""
+}
+
+func TestModelSpec_threads(t *testing.T) {
+	spec := ModelSpec{Name: "fake", CPUSet: "0-1"}
+	assert.Equal(t, "2", spec.environment(nil)["OMP_NUM_THREADS"])
+	spec.Threads = 3
+	env := spec.environment(nil)
+	assert.Equal(t, "3", env["MKL_NUM_THREADS"])
+	spec.Env = map[string]string{"OMP_NUM_THREADS": "4"}
+	env = spec.environment(nil)
+	assert.Equal(t, "4", env["OMP_NUM_THREADS"])
+	assert.Equal(t, "3", env["OPENBLAS_NUM_THREADS"])
+	assert.Empty(t, (&ModelSpec{Name: "fake"}).environment(nil)["OMP_NUM_THREADS"])
+}
+
+func TestModelSpec_checkCPUs(t *testing.T) {
+	available, err := availableCPUs()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, available)
+	spec := ModelSpec{Name: "fake", CPUSet: available.String()}
+	assert.Nil(t, spec.checkCPUs())
+	spec.CPUSet = "100000"
+	assert.Contains(t, fmt.Sprint(spec.checkCPUs()), "cpus 100000 not available")
+	spec.CPUSet = ""
+	spec.Threads = -1
+	assert.Equal(t, "negative threads", fmt.Sprint(spec.checkCPUs()))
+}
+
+func TestModel_affinity(t *testing.T) {
+	available, _ := availableCPUs()
+	cpu := available[len(available)-1]
+	m := newModel(ModelSpec{Name: "fake", Action: "fake", Load: "_test/model.sh", CPUSet: fmt.Sprint(cpu)})
+	executor := m.newExecutor(map[string]string{})
+	assert.Nil(t, executor.Start(false, 0))
+	defer executor.Stop()
+
+	set, err := affinity(executor.Pid())
+	assert.Nil(t, err)
+	assert.Equal(t, cpuSet{cpu}, set)
+	environ, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", executor.Pid()))
+	assert.Contains(t, strings.Split(string(environ), "\x00"), "OMP_NUM_THREADS=1")
+}
+
+func TestLimits_affinityGroup(t *testing.T) {
+	available, _ := availableCPUs()
+	cpu := available[len(available)-1]
+	proc := NewModelExecutor(nil, nil, "stubborn", true, "_test/stubborn.sh", map[string]string{})
+	assert.Nil(t, proc.Start(false, 0))
+	defer proc.kill()
+	child := childOf(t, func() ([]byte, error) { return proc.Interact(context.Background(), []byte("{}"), 0) })
+
+	// the child was spawned before the limits, it is found in the process group
+	limits := &resourceLimits{cpuset: cpuSet{cpu}}
+	assert.Nil(t, limits.apply("stubborn", proc.Pid(), true, nil))
+	for _, pid := range []int{proc.Pid(), child} {
+		set, _ := affinity(pid)
+		assert.Equal(t, cpuSet{cpu}, set)
+	}
+}
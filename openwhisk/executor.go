@@ -25,6 +25,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -34,13 +36,27 @@ const OutputGuard = "XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX\n"
 // DefaultTimeoutStart to wait for a process to start
 var DefaultTimeoutStart = 5 * time.Millisecond
 
+// DefaultTimeoutAck is how long an action requested protocol version 2 can take to acknowledge it,
+// when no acknowledgement is required otherwise, before falling back to version 1
+var DefaultTimeoutAck = time.Second
+
 // Executor is the container and the guardian  of a child process
 // It starts a command, feeds input and output, read logs and control its termination
 type Executor struct {
 	cmd    *exec.Cmd
 	input  io.WriteCloser
 	output *bufio.Reader
-	exited chan bool
+	// pipeOut is read by output, its deadline bounds the wait for the acknowledgement
+	pipeOut *os.File
+	exited  chan bool
+	// exit describes how the process terminated, set before exited is closed
+	exit string
+	// mutex serializes the exchanges with the process
+	mutex sync.Mutex
+	// protocol is the version requested until the acknowledgement, then the negotiated one
+	protocol int
+	// lastID is the id of the last request sent with protocol version 2
+	lastID uint64
 }
 
 // NewExecutor creates a child subprocess using the provided command line,
@@ -50,13 +66,15 @@ type Executor struct {
 func NewExecutor(logout *os.File, logerr *os.File, command string, env map[string]string, args ...string) (proc *Executor) {
 	//env:子进程的环境变量; cmd + arg: 真正的命令
 	cmd := exec.Command(command, args...) //创建一个可以用来启动命令的 *Cmd
+	// in its own process group, so that stopping it also stops the processes it spawned
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Stdout = logout
 	cmd.Stderr = logerr
 	cmd.Env = []string{} //初始化 *Cmd 的 Env 字段，这个字段用来设置子进程的环境变量
 	for k, v := range env {
 		cmd.Env = append(cmd.Env, k+"="+v) //遍历传入的环境变量 env，并将它们添加到 *Cmd 的 Env 字段
 	}
-	Debug("env: %v", cmd.Env) //如果 Debugging 是 true，则输出环境变量到调试日志
+	executorLog.Debugf("env: %s", envNames(env)) //只输出环境变量的名字，不输出它们的值
 	if Debugging {
 		cmd.Env = append(cmd.Env, "OW_DEBUG=/tmp/action.log")
 	}
@@ -77,19 +95,41 @@ func NewExecutor(logout *os.File, logerr *os.File, command string, env map[strin
 		cmd,
 		input,
 		output,
+		pipeOut,
 		make(chan bool),
+		"",
+		sync.Mutex{},
+		requestedProtocol(env),
+		0,
 	}
 }
 
 // Interact interacts with the underlying process
 func (proc *Executor) Interact(in []byte) ([]byte, error) {
+	proc.mutex.Lock()
+	defer proc.mutex.Unlock()
 	// input to the subprocess
-	proc.input.Write(in)
-	proc.input.Write([]byte("\n"))
+	var id uint64
+	if proc.protocol == ProtocolV2 {
+		proc.lastID++
+		id = proc.lastID
+		writeFrame(proc.input, id, in)
+	} else {
+		proc.input.Write(in)
+		proc.input.Write([]byte("\n"))
+	}
 
 	chout := make(chan []byte)
 
 	go func() {
+		if proc.protocol == ProtocolV2 {
+			out, err := readReply(proc.output, id, "action", nil)
+			if err != nil {
+				executorLog.Debugf("cannot read the answer: %v", err)
+			}
+			chout <- out
+			return
+		}
 		out, err := proc.output.ReadBytes('\n')
 		if err == nil {
 			chout <- out
@@ -125,27 +165,39 @@ func (proc *Executor) Exited() bool {
 // ActionAck is the expected data structure for the action acknowledgement
 type ActionAck struct {
 	Ok bool `json:"ok"`
+	// Protocol is the version of the protocol the action speaks, 0 for version 1
+	Protocol int `json:"protocol,omitempty"`
 }
 
 // Start execution of the command
 // if the flag ack is true, wait forever for an acknowledgement
 // if the flag ack is false wait a bit to check if the command exited
-// returns an error if the program fails
+// returns an error if the program fails.
+// Requesting protocol version 2 requires an acknowledgement,
+// confirming the version the action speaks: if it is not required otherwise,
+// an action not acknowledging within DefaultTimeoutAck is spoken to with version 1.
 func (proc *Executor) Start(waitForAck bool) error {
+	fallback := false
+	if proc.protocol > ProtocolV1 && !waitForAck {
+		waitForAck = true
+		fallback = true
+	}
 	// start the underlying executable
-	Debug("Start:")
+	executorLog.Debugf("Start:")
 	err := proc.cmd.Start()
 	if err != nil {
-		Debug("run: early exit")
+		executorLog.Debugf("run: early exit")
 		proc.cmd = nil // no need to kill
 		return fmt.Errorf("command exited")
 	}
-	Debug("pid: %d", proc.cmd.Process.Pid)
+	executorLog.Debugf("pid: %d", proc.cmd.Process.Pid)
 
-	go func() {
-		proc.cmd.Wait()
-		proc.exited <- true
-	}()
+	go func(cmd *exec.Cmd) {
+		cmd.Wait()
+		proc.exit = exitStatus(cmd.ProcessState)
+		executorLog.Debugf("pid %d terminated: %s", cmd.Process.Pid, proc.exit)
+		close(proc.exited)
+	}(proc.cmd)
 
 	// not waiting for an ack, so use a timeout
 	if !waitForAck {
@@ -158,11 +210,23 @@ func (proc *Executor) Start(waitForAck bool) error {
 	}
 
 	// wait for acknowledgement
-	Debug("waiting for an ack")
+	executorLog.Debugf("waiting for an ack")
+	if fallback {
+		proc.pipeOut.SetReadDeadline(time.Now().Add(DefaultTimeoutAck))
+	}
 	ack := make(chan error)
 	go func() {
 		out, err := proc.output.ReadBytes('\n')
-		Debug("received ack %s", out)
+		executorLog.Debugf("received ack %s", out)
+		if fallback {
+			proc.pipeOut.SetReadDeadline(time.Time{})
+			if errors.Is(err, os.ErrDeadlineExceeded) && len(out) == 0 {
+				executorLog.Warnf("no acknowledgement of protocol version %d after %v, speaking version %d", proc.protocol, DefaultTimeoutAck, ProtocolV1)
+				proc.protocol = ProtocolV1
+				ack <- nil
+				return
+			}
+		}
 		if err != nil {
 			ack <- err
 			return
@@ -179,6 +243,8 @@ func (proc *Executor) Start(waitForAck bool) error {
 			ack <- fmt.Errorf("The action did not initialize properly.")
 			return
 		}
+		proc.protocol = negotiatedProtocol(proc.protocol, ackData)
+		executorLog.Debugf("speaking protocol version %d", proc.protocol)
 		ack <- nil
 	}()
 	// wait for ack or unexpected termination
@@ -192,12 +258,20 @@ func (proc *Executor) Start(waitForAck bool) error {
 	}
 }
 
-// Stop will kill the process
-// and close the channels
+// Stop terminates the process and the processes it spawned,
+// killing them if they do not terminate within DefaultStopGrace
 func (proc *Executor) Stop() {
-	Debug("stopping original executor")
-	if proc.cmd != nil {
-		proc.cmd.Process.Kill()
+	executorLog.Debugf("stopping original executor")
+	if proc.cmd != nil && proc.cmd.Process != nil {
+		terminate("action", proc.cmd.Process.Pid, true, proc.exited, DefaultStopGrace)
 		proc.cmd = nil
 	}
 }
+
+// ExitStatus describes how the process terminated, empty while it runs
+func (proc *Executor) ExitStatus() string {
+	if !proc.Exited() {
+		return ""
+	}
+	return proc.exit
+}
@@ -17,6 +17,7 @@
 package openwhisk
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"time"
@@ -91,27 +92,27 @@ func ExampleNewExecutor_hello() {
 }
 
 //Pre-Loaidng Test
-func ExampleLoadExecutor_hello() {
+func ExampleNewModelExecutor_hello() {
 	log, _ := ioutil.TempFile("", "log")
 	//proc := NewExecutor(log, log, "_test/hello.sh", m)
-	proc := Newresnet18Executor(log, log, "_test/pytest.sh", m)
+	proc := NewModelExecutor(log, log, "resnet18", true, "_test/pytest.sh", m)
 
 	print("getMMMM")
 	print(m)
 
-	if proc.IsStarted() {
+	if proc.State() != StateUnloaded {
 		print("getERROR")
 	} else {
 		fmt.Println("Executor has not started")
 	}
-	err := proc.Start(false)
+	err := proc.Start(false, 0)
 	fmt.Println(err)
 	// Check if proc has started
 
 	//fmt.Println(err)
 	//print("getERROR")
 	time.Sleep(3 * time.Second)
-	res, _ := proc.Interact([]byte("anything"))
+	res, _ := proc.Interact(context.Background(), []byte("anything"), 0)
 	//res, _ := proc.Interact2("anything")
 	fmt.Printf("%s", res)
 	print("   AftergetERROR")
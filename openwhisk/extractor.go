@@ -59,10 +59,10 @@ func (ap *ActionProxy) ExtractAction(buf *[]byte, suffix string) (string, error)
 		jar := os.Getenv("OW_SAVE_JAR")
 		if jar != "" {
 			jarFile := newDir + "/" + jar
-			Debug("Extract Action, checking if it is a jar first")
+			extractorLog.Debugf("Extract Action, checking if it is a jar first")
 			return jarFile, UnzipOrSaveJar(*buf, newDir, jarFile)
 		}
-		Debug("Extract Action, assuming a zip")
+		extractorLog.Debugf("Extract Action, assuming a zip")
 		return file, Unzip(*buf, newDir)
 	}
 	return file, ioutil.WriteFile(file, *buf, 0755)
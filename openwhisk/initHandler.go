@@ -22,7 +22,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -51,18 +50,19 @@ func sendOK(w http.ResponseWriter) {
 }
 
 func (ap *ActionProxy) initHandler(w http.ResponseWriter, r *http.Request) {
+	log := requestLog(r.Context())
 
 	// you can do multiple initializations when debugging
 	if ap.initialized && !Debugging {
 		msg := "Cannot initialize the action more than once."
 		sendError(w, http.StatusForbidden, msg)
-		log.Println(msg)
+		log.Errorf("%s", msg)
 		return
 	}
 
 	// read body of the request
 	if ap.compiler != "" {
-		Debug("compiler: " + ap.compiler)
+		log.Debugf("compiler: %s", ap.compiler)
 	}
 
 	body, err := ioutil.ReadAll(r.Body)
@@ -73,9 +73,7 @@ func (ap *ActionProxy) initHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// decode request parameters
-	if len(body) < 1000 {
-		Debug("init: decoding %s\n", string(body))
-	}
+	log.Debugf("init: decoding %d bytes", len(body))
 
 	var request initRequest
 	err = json.Unmarshal(body, &request)
@@ -103,20 +101,21 @@ func (ap *ActionProxy) initHandler(w http.ResponseWriter, r *http.Request) {
 	// extract code eventually decoding it
 	var buf []byte
 	if request.Value.Binary {
-		Debug("it is binary code")
+		log.Debugf("it is binary code")
 		buf, err = base64.StdEncoding.DecodeString(request.Value.Code)
 		if err != nil {
 			sendError(w, http.StatusBadRequest, "cannot decode the request: "+err.Error())
 			return
 		}
 	} else {
-		Debug("it is source code")
+		log.Debugf("it is source code")
 		buf = []byte(request.Value.Code)
 	}
 
 	// if a compiler is defined try to compile
 	_, err = ap.ExtractAndCompile(&buf, main)
 	if err != nil {
+		log.Errorf("cannot generate the binary: %v", err)
 		if os.Getenv("OW_LOG_INIT_ERROR") == "" {
 			sendError(w, http.StatusBadGateway, err.Error())
 		} else {
@@ -131,6 +130,7 @@ func (ap *ActionProxy) initHandler(w http.ResponseWriter, r *http.Request) {
 	// start an action
 	err = ap.StartLatestAction()
 	if err != nil {
+		log.Errorf("cannot start the action: %v", err)
 		if os.Getenv("OW_LOG_INIT_ERROR") == "" {
 			sendError(w, http.StatusBadGateway, "cannot start action: "+err.Error())
 		} else {
@@ -176,7 +176,7 @@ func (ap *ActionProxy) ExtractAndCompile(buf *[]byte, main string) (string, erro
 	}
 
 	// ok let's try to compile
-	Debug("compiling: %s main: %s", file, main)
+	compilerLog.Debugf("compiling: %s main: %s", file, main)
 	os.Mkdir(binDir, 0755)
 	err = ap.CompileAction(main, srcDir, binDir)
 	if err != nil {
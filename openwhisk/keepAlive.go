@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// setKeepAlive sets how long the model stays loaded while idle, in milliseconds:
+// 0 restores the keep_alive_ms of the model, and a negative value keeps it loaded for ever
+func (m *Model) setKeepAlive(ms int) {
+	if ms == 0 {
+		ms = m.KeepAliveMS
+	}
+	if ms < 0 {
+		ms = 0
+	}
+	atomic.StoreInt64(&m.keepAlive, int64(time.Duration(ms)*time.Millisecond))
+}
+
+// keepAliveTTL is how long the model stays loaded while idle, 0 for ever
+func (m *Model) keepAliveTTL() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.keepAlive))
+}
+
+// expiry is when the model will be offloaded if it stays idle,
+// the zero time if never. The caller must hold the mutex.
+func (m *Model) expiry() time.Time {
+	ttl := m.keepAliveTTL()
+	if ttl <= 0 || len(m.replicas) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, atomic.LoadInt64(&m.lastUsed)).Add(ttl)
+}
+
+// armKeepAlive starts the timer offloading the model when it expires,
+// replacing the previous one. The caller must hold the mutex for writing.
+func (ap *ActionProxy) armKeepAlive(m *Model) {
+	if m.reaper != nil {
+		m.reaper.Stop()
+		m.reaper = nil
+	}
+	m.reaperGen++
+	expiry := m.expiry()
+	if expiry.IsZero() {
+		return
+	}
+	gen := m.reaperGen
+	m.reaper = time.AfterFunc(time.Until(expiry), func() { ap.reap(m, gen) })
+}
+
+// reap offloads the model if it is idle since its keep alive,
+// or waits for its new expiry if it was used meanwhile.
+// There is no load nor run in progress to interrupt when it is offloaded.
+func (ap *ActionProxy) reap(m *Model, gen int) {
+	m.mutex.Lock()
+	if gen != m.reaperGen {
+		// replaced by a newer timer
+		m.mutex.Unlock()
+		return
+	}
+	expiry := m.expiry()
+	busy := m.loading()
+	for _, rep := range m.replicas {
+		if atomic.LoadInt32(&rep.inflight) > 0 {
+			busy = true
+		}
+	}
+	if expiry.IsZero() || busy || time.Now().Before(expiry) {
+		if busy {
+			m.touch()
+		}
+		ap.armKeepAlive(m)
+		m.mutex.Unlock()
+		return
+	}
+	// offloaded without releasing the mutex, so that a run or a load
+	// renewing the model either came before the check or finds it offloaded
+	defer m.mutex.Unlock()
+	modelLog.With("model", m.Name).Infof("idle for %v, offloading it", m.keepAliveTTL())
+	m.scale(ap.env, 0)
+}
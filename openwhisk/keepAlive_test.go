@@ -0,0 +1,101 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepAlive(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "fake", Action: "/guest/fake", Load: "_test/model.sh", Cold: "_test/cold.sh", KeepAliveMS: 300},
+	})
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+	m := ap.models.get("fake")
+	loaded := func() bool {
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+		return m.isLoaded()
+	}
+
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/fake"}`)
+	assert.Equal(t, http.StatusOK, status)
+	fake := getStatus(t, ts).Models[0]
+	assert.Equal(t, int64(300), fake.KeepAliveMS)
+	assert.NotNil(t, fake.ExpiresAt)
+
+	// every run postpones the offload
+	for i := 0; i < 4; i++ {
+		time.Sleep(150 * time.Millisecond)
+		_, status, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/fake","value":{}}`)
+		assert.Equal(t, http.StatusOK, status)
+	}
+	assert.True(t, loaded())
+
+	// idle, it is offloaded
+	time.Sleep(500 * time.Millisecond)
+	assert.False(t, loaded())
+	fake = getStatus(t, ts).Models[0]
+	assert.Equal(t, StateUnloaded, fake.State)
+	assert.Nil(t, fake.ExpiresAt)
+
+	// unless a load keeps it for ever
+	_, status, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/fake","keep_alive_ms":-1}`)
+	assert.Equal(t, http.StatusOK, status)
+	time.Sleep(500 * time.Millisecond)
+	assert.True(t, loaded())
+	assert.Nil(t, getStatus(t, ts).Models[0].ExpiresAt)
+}
+
+func TestKeepAlive_renewed(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "fake", Action: "/guest/fake", Load: "_test/model.sh", Cold: "_test/cold.sh", KeepAliveMS: 50},
+	})
+	defer ap.StopAllExecutorsExcept("")
+	m := ap.models.get("fake")
+	assert.Nil(t, m.scale(ap.env, 1))
+	m.touch()
+	m.mutex.Lock()
+	ap.armKeepAlive(m)
+	m.mutex.Unlock()
+
+	// the model used while the reaper waits for the mutex stays loaded
+	m.mutex.Lock()
+	time.Sleep(100 * time.Millisecond)
+	m.touch()
+	m.mutex.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	m.mutex.RLock()
+	assert.True(t, m.isLoaded())
+	m.mutex.RUnlock()
+
+	// until it expires again
+	time.Sleep(100 * time.Millisecond)
+	m.mutex.RLock()
+	assert.False(t, m.isLoaded())
+	m.mutex.RUnlock()
+}
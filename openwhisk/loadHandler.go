@@ -19,10 +19,11 @@ package openwhisk
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	"time"
 )
 
 func (ap *ActionProxy) loadHandler(w http.ResponseWriter, r *http.Request) {
@@ -46,212 +47,146 @@ func (ap *ActionProxy) loadHandler(w http.ResponseWriter, r *http.Request) {
 		sendError(w, http.StatusBadRequest, fmt.Sprintf("Error reading request body: %v", err))
 		return
 	}
-	actionName := req.ActionName
-	if strings.Contains(actionName, "ptest04") {
-		Debug("LoadHandler starts pre-loading ResNet18.")
-		// check if you have an action
-		if ap.theresnet18Executor == nil || ap.theresnet18Executor.cmd == nil {
-			Debug("Just create a new res18executor")
-			NEWresnet18Executor1 := Newresnet18Executor(ap.outFile, ap.errFile, "_test/loadres18.sh", ap.env)
-			ap.theresnet18Executor = NEWresnet18Executor1
-		}
-
-		if ap.theresnet18Executor.started {
-			Debug("already loaded resnet18")
-			return
-		}
-
-		//Pre-load libraries & model
-		err := ap.theresnet18Executor.Start(false)
-
-		Debug("Handler Finished pre-loading ResNet18.")
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited (loadHandler)")
-			//ap.theresnet18Executor = nil
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("command exited！！"))
-			Debug(err.Error())
-			return
-		}
-	} else if strings.Contains(actionName, "ptest05") {
-		Debug("LoadHandler starts pre-loading ResNet50.")
-		// check if you have an action
-		if ap.theresnet50Executor == nil || ap.theresnet50Executor.cmd == nil {
-			Debug("Just create a new res50executor")
-			NEWresnet50Executor1 := Newresnet50Executor(ap.outFile, ap.errFile, "_test/loadres50.sh", ap.env)
-			ap.theresnet50Executor = NEWresnet50Executor1
-		}
-		if ap.theresnet50Executor.started {
-			Debug("already loaded resnet50")
-			return
-		}
-
-		//NEWresnet50Executor1 := Newresnet50Executor(ap.outFile, ap.errFile, "_test/loadres50.sh", ap.env)
-		//ap.theresnet50Executor = NEWresnet50Executor1
-
-		//Pre-load libraries & model
-		err := ap.theresnet50Executor.Start(false)
-		Debug("Handler Finished pre-loading ResNet50.")
-
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited (load)")
-			//ap.theresnet50Executor = nil
-			Debug(err.Error())
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("command exited"))
-			return
-		}
-	} else if strings.Contains(actionName, "ptest06") {
-		Debug("LoadHandler starts pre-loading ResNet152.")
-		// check if you have an action
-		if ap.theresnet152Executor == nil || ap.theresnet152Executor.cmd == nil {
-			Debug("Just create a new res152executor")
-			NEWresnet152Executor1 := Newresnet152Executor(ap.outFile, ap.errFile, "_test/loadres152.sh", ap.env)
-			ap.theresnet152Executor = NEWresnet152Executor1
-		}
-		if ap.theresnet152Executor.started {
-			Debug("already loaded resnet152")
-			return
-		}
-
-		//NEWresnet152Executor1 := Newresnet152Executor(ap.outFile, ap.errFile, "_test/loadres152.sh", ap.env)
-		//ap.theresnet152Executor = NEWresnet152Executor1
+	m := ap.models.match(req.ActionName)
+	if m == nil {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Not defined this model!"))
+		return
+	}
 
-		//Pre-load libraries & model
-		err := ap.theresnet152Executor.Start(false)
-		Debug("Handler Finished pre-loading ResNet152.")
+	log := requestLog(r.Context()).With("model", m.Name)
 
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited(load)")
-			//ap.theresnet152Executor = nil
-			Debug(err.Error())
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("command exited"))
-			return
-		}
-	} else if strings.Contains(actionName, "ptest01") {
-		Debug("LoadHandler starts pre-loading alex.")
-		// check if you have an action
-		if ap.thealexExecutor == nil || ap.thealexExecutor.cmd == nil {
-			Debug("Just create a new alexexecutor")
-			NEWalexExecutor1 := NewalexExecutor(ap.outFile, ap.errFile, "_test/loadalex.sh", ap.env)
-			ap.thealexExecutor = NEWalexExecutor1
-		}
-		if ap.thealexExecutor.started {
-			Debug("already loaded alex")
-			return
-		}
+	// cancel the loads in progress, keeping the replicas already loaded
+	if req.Cancel {
+		log.Debugf("cancelling the loads")
+		m.cancelLoad()
+		sendLoadResponse(w, http.StatusOK, m.loadResponse(nil))
+		return
+	}
 
-		//Pre-load libraries & model
-		err := ap.thealexExecutor.Start(false)
+	log.Debugf("pre-loading")
 
-		Debug("Handler Finished pre-loading alex.")
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited (loadHandler)")
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("command exited！！"))
-			Debug(err.Error())
-			return
-		}
-	} else if strings.Contains(actionName, "ptest02") {
-		Debug("LoadHandler starts pre-loading vgg.")
-		// check if you have an action
-		if ap.thevggExecutor == nil || ap.thevggExecutor.cmd == nil {
-			Debug("Just create a new vggexecutor")
-			NEWvggExecutor1 := NewvggExecutor(ap.outFile, ap.errFile, "_test/loadvgg.sh", ap.env)
-			ap.thevggExecutor = NEWvggExecutor1
-		}
-		if ap.thevggExecutor.started {
-			Debug("already loaded vgg")
-			return
+	// without an explicit count keep the replicas already loaded, or load one
+	replicas := req.Replicas
+	if replicas < 0 {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid number of replicas: %d", replicas))
+		return
+	}
+	if replicas == 0 {
+		m.mutex.RLock()
+		replicas = m.replicaCount()
+		m.mutex.RUnlock()
+		if replicas == 0 {
+			replicas = 1
 		}
+	}
 
-		//Pre-load libraries & model
-		err := ap.thevggExecutor.Start(false)
-
-		Debug("Handler Finished pre-loading vgg.")
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited (loadHandler)")
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("command exited！！"))
-			Debug(err.Error())
-			return
-		}
-	} else if strings.Contains(actionName, "ptest03") {
-		Debug("LoadHandler starts pre-loading inception.")
-		// check if you have an action
-		if ap.theinceptionExecutor == nil || ap.theinceptionExecutor.cmd == nil {
-			Debug("Just create a new inceptionexecutor")
-			NEWinceptionExecutor1 := NewinceptionExecutor(ap.outFile, ap.errFile, "_test/loadinception.sh", ap.env)
-			ap.theinceptionExecutor = NEWinceptionExecutor1
-		}
-		if ap.theinceptionExecutor.started {
-			Debug("already loaded inception")
-			return
-		}
+	m.setKeepAlive(req.KeepAliveMS)
+	job := ap.startLoad(m, replicas)
+	if req.Async {
+		sendLoadResponse(w, http.StatusAccepted, m.loadResponse(nil))
+		return
+	}
+	<-job.done
+	if job.err != nil {
+		log.Warnf("cannot pre-load: %v", job.err)
+	}
+	sendLoadResponse(w, job.status, m.loadResponse(job))
+	log.Infof("pre-loaded in %v", job.duration)
+}
 
-		//Pre-load libraries & model
-		err := ap.theinceptionExecutor.Start(false)
+// loadResponse is the answer to /load: the state of the model,
+// and the outcome of the load if it completed
+type loadResponse struct {
+	Ok       bool          `json:"ok"`
+	State    ExecutorState `json:"state"`
+	Replicas int           `json:"replicas"`
+	LoadMS   int64         `json:"load_ms,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
 
-		Debug("Handler Finished pre-loading inception.")
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited (loadHandler)")
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("command exited！！"))
-			Debug(err.Error())
-			return
-		}
-	} else if strings.Contains(actionName, "ptest07") {
-		Debug("LoadHandler starts pre-loading googlenet.")
-		// check if you have an action
-		if ap.thegooglenetExecutor == nil || ap.thegooglenetExecutor.cmd == nil {
-			Debug("Just create a new googlenetexecutor")
-			NEWgooglenetExecutor1 := NewgooglenetExecutor(ap.outFile, ap.errFile, "_test/loadgooglenet.sh", ap.env)
-			ap.thegooglenetExecutor = NEWgooglenetExecutor1
-		}
-		if ap.thegooglenetExecutor.started {
-			Debug("already loaded googlenet")
-			return
+// loadResponse describes the model after the given load, or now if job is nil
+func (m *Model) loadResponse(job *loadJob) loadResponse {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	res := loadResponse{Ok: true, State: m.state(), Replicas: m.replicaCount()}
+	if job != nil {
+		res.LoadMS = job.duration.Milliseconds()
+		if job.err != nil {
+			res.Ok = false
+			res.Error = job.err.Error()
+			if res.State == StateUnloaded && job.err != errLoadCancelled {
+				res.State = StateCrashed
+			}
 		}
+	}
+	return res
+}
 
-		//Pre-load libraries & model
-		err := ap.thegooglenetExecutor.Start(false)
+func sendLoadResponse(w http.ResponseWriter, code int, res loadResponse) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+	w.Write([]byte("\n"))
+}
 
-		Debug("Handler Finished pre-loading googlenet.")
-		// check for early termination
+// scaleModel starts or stops replicas of the model until the number of replicas of the job are ready.
+// The replicas are started without holding the mutex, so the model keeps serving meanwhile.
+// On failure it returns the http status to answer with and the reason.
+func (ap *ActionProxy) scaleModel(m *Model, job *loadJob) (int, error) {
+	n := job.replicas
+	// evict other models if there is no room for the replicas
+	if n > 0 {
+		reserved, status, err := ap.makeRoom(m, n)
 		if err != nil {
-			Debug("WARNING! Command exited (loadHandler)")
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("command exited！！"))
-			Debug(err.Error())
-			return
-		}
-	} else if strings.Contains(actionName, "ptest08") {
-		Debug("LoadHandler starts pre-loading bert.")
-		// check if you have an action
-		if ap.thebertExecutor == nil || ap.thebertExecutor.cmd == nil {
-			Debug("Just create a new bertexecutor")
-			NEWbertExecutor1 := NewbertExecutor(ap.outFile, ap.errFile, "_test/loadbert.sh", ap.env)
-			ap.thebertExecutor = NEWbertExecutor1
-		}
-		if ap.thebertExecutor.started {
-			Debug("already loaded bert")
-			return
+			return status, err
 		}
+		// the started replicas are measured from now on
+		defer ap.release(reserved)
+	}
 
-		//Pre-load libraries & model
-		err := ap.thebertExecutor.Start(false)
+	// wait for the runs in progress on the model
+	m.mutex.Lock()
+	if m.replicaCount() == n && len(m.replicas) == n {
+		ap.armKeepAlive(m)
+		m.mutex.Unlock()
+		modelLog.With("model", m.Name).Debugf("already loaded %d replicas", n)
+		return http.StatusOK, nil
+	}
+	m.trim(n)
+	m.queue.resize(len(m.replicas))
+	missing := n - len(m.replicas)
+	m.mutex.Unlock()
+
+	start := time.Now()
+	started, err := m.startReplicas(ap.env, missing, job)
+	if err == nil && len(started) > 0 {
+		m.stats.loaded(time.Since(start))
+	}
 
-		Debug("Handler Finished pre-loading bert.")
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited (loadHandler)")
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("command exited！！"))
-			Debug(err.Error())
-			return
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if job.isCancelled() {
+		for _, rep := range started {
+			rep.stop()
 		}
-	} else {
-		sendError(w, http.StatusBadRequest, fmt.Sprintf("Not defined this model!"))
-		return
+		return http.StatusConflict, errLoadCancelled
 	}
-
+	m.add(ap.env, started)
+	m.queue.resize(len(m.replicas))
+	if n > 0 && len(m.replicas) > 0 {
+		m.touch()
+	}
+	ap.armKeepAlive(m)
+	if errors.Is(err, ErrTimeout) {
+		return http.StatusGatewayTimeout, err
+	}
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("command exited: %v", err)
+	}
+	return http.StatusOK, nil
 }
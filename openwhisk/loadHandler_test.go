@@ -0,0 +1,166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad_ack(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "loading", Action: "/guest/loading", Load: "_test/loading.sh", Cold: "_test/cold.sh", Ack: true},
+		{Name: "stuck", Action: "/guest/stuck", Load: "_test/loading.sh", Cold: "_test/cold.sh", Ack: true,
+			LoadTimeoutMS: 100, MaxRestarts: -1, Env: map[string]string{"LOAD_DELAY": "5"}},
+	})
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	// the answer waits for the acknowledgement
+	body, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/loading"}`)
+	assert.Equal(t, http.StatusOK, status)
+	var res loadResponse
+	assert.Nil(t, json.Unmarshal([]byte(body), &res))
+	assert.True(t, res.Ok)
+	assert.Equal(t, StateReady, res.State)
+	assert.Equal(t, 1, res.Replicas)
+	assert.True(t, res.LoadMS >= 300)
+
+	body, status, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/loading","value":{}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "fake")
+
+	// a model not acknowledging in time is not loaded
+	body, status, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/stuck"}`)
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	res = loadResponse{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &res))
+	assert.False(t, res.Ok)
+	assert.Equal(t, StateCrashed, res.State)
+	assert.Equal(t, 0, res.Replicas)
+	assert.Contains(t, res.Error, "timed out")
+	assert.True(t, res.LoadMS < 5000)
+}
+
+func TestLoad_async(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "loading", Action: "/guest/loading", Load: "_test/loading.sh", Cold: "_test/cold.sh", Ack: true},
+	})
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	body, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/loading","async":true}`)
+	assert.Equal(t, http.StatusAccepted, status)
+	var res loadResponse
+	assert.Nil(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, StateLoading, res.State)
+
+	// the run waits for the load rather than starting a cold run
+	body, status, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/loading","value":{}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "fake")
+
+	body, status, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/loading"}`)
+	assert.Equal(t, http.StatusOK, status)
+	res = loadResponse{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, StateReady, res.State)
+	assert.Equal(t, 1, res.Replicas)
+}
+
+func TestLoad_cancel(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "stuck", Action: "/guest/stuck", Load: "_test/loading.sh", Cold: "_test/cold.sh", Ack: true,
+			Env: map[string]string{"LOAD_DELAY": "5"}},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+	m := ap.models.get("stuck")
+
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/stuck","async":true}`)
+	assert.Equal(t, http.StatusAccepted, status)
+
+	// a run not willing to wait for the load times out
+	body, status, _ := doPost(ts.URL+"/run", `{"action_name":"/guest/stuck","timeout_ms":100,"value":{}}`)
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	assert.Contains(t, body, "still loading")
+
+	// a synchronous load waits for the previous one, and is cancelled with it
+	loaded := make(chan int)
+	go func() {
+		_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/stuck","replicas":2}`)
+		loaded <- status
+	}()
+	time.Sleep(100 * time.Millisecond)
+	body, status, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/stuck","cancel":true}`)
+	assert.Equal(t, http.StatusOK, status)
+	select {
+	case status = <-loaded:
+		assert.Equal(t, http.StatusConflict, status)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the load was not cancelled")
+	}
+	m.mutex.RLock()
+	assert.Equal(t, StateUnloaded, m.state())
+	m.mutex.RUnlock()
+
+	// the model not being loaded anymore, runs are cold
+	body, status, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/stuck","value":{}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "cold")
+}
+
+func TestLoad_coldRunning(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "slow", Action: "/guest/slow", Simulate: &SimulatedModel{LoadMS: 500}},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	done := make(chan bool)
+	go func() {
+		doPost(ts.URL+"/run", `{"action_name":"/guest/slow","value":{}}`)
+		close(done)
+	}()
+	for i := 0; i < 100 && !ap.HasAnyExecutorStarted(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a load during a cold run is refused explicitly
+	body, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/slow"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Contains(t, body, "a cold run is in progress")
+	<-done
+	_, status, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/slow"}`)
+	assert.Equal(t, http.StatusOK, status)
+}
@@ -0,0 +1,213 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errLoadCancelled is returned when a load is cancelled before completing
+var errLoadCancelled = errors.New("load cancelled")
+
+// loadJob is a change of the number of replicas of a model in progress.
+// The loads of a model run one after the other, in the order they were requested.
+type loadJob struct {
+	replicas int
+	// prev is the load requested before, to wait for, protected by the mutex of the model
+	prev *loadJob
+	// done is closed when the load completes
+	done      chan struct{}
+	cancelled chan struct{}
+	once      sync.Once
+	// mutex protects the executors being started
+	mutex    sync.Mutex
+	starting []ModelExecutor
+	// requested is when the load was requested; status, err and duration
+	// are the outcome of the load, valid once done is closed
+	requested time.Time
+	status    int
+	err       error
+	duration  time.Duration
+}
+
+func newLoadJob(replicas int, prev *loadJob) *loadJob {
+	return &loadJob{
+		replicas:  replicas,
+		prev:      prev,
+		done:      make(chan struct{}),
+		cancelled: make(chan struct{}),
+		requested: time.Now(),
+	}
+}
+
+// finished checks if the load completed
+func (job *loadJob) finished() bool {
+	select {
+	case <-job.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// isCancelled checks if the load was cancelled
+func (job *loadJob) isCancelled() bool {
+	select {
+	case <-job.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// cancel interrupts the load, aborting the executors being started
+func (job *loadJob) cancel() {
+	job.once.Do(func() {
+		close(job.cancelled)
+		job.mutex.Lock()
+		defer job.mutex.Unlock()
+		for _, executor := range job.starting {
+			executor.Abort()
+		}
+	})
+}
+
+// track records an executor is being started, so that cancelling the load aborts it.
+// It returns false if the load was already cancelled.
+func (job *loadJob) track(executor ModelExecutor) bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if job.isCancelled() {
+		return false
+	}
+	job.starting = append(job.starting, executor)
+	return true
+}
+
+// untrack records the executor finished starting
+func (job *loadJob) untrack(executor ModelExecutor) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	for i, ex := range job.starting {
+		if ex == executor {
+			job.starting = append(job.starting[:i], job.starting[i+1:]...)
+			return
+		}
+	}
+}
+
+// startLoad requests to change the number of replicas of the model to n,
+// after the loads already requested. The returned job tracks the load.
+func (ap *ActionProxy) startLoad(m *Model, n int) *loadJob {
+	m.mutex.Lock()
+	job := newLoadJob(n, m.load)
+	m.load = job
+	m.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			job.duration = time.Since(job.requested)
+			close(job.done)
+		}()
+		m.mutex.RLock()
+		prev := job.prev
+		m.mutex.RUnlock()
+		if prev != nil {
+			select {
+			case <-prev.done:
+			case <-job.cancelled:
+			}
+			m.mutex.Lock()
+			job.prev = nil
+			m.mutex.Unlock()
+		}
+		if job.isCancelled() {
+			job.status, job.err = http.StatusConflict, errLoadCancelled
+			return
+		}
+		job.status, job.err = ap.scaleModel(m, job)
+		if job.err != nil && job.err != errLoadCancelled {
+			m.stats.fail(job.err)
+		}
+	}()
+	return job
+}
+
+// loading checks if a load of the model is in progress,
+// the caller must hold the mutex
+func (m *Model) loading() bool {
+	return m.load != nil && !m.load.finished()
+}
+
+// state is the lifecycle state of the model: loading while a load is in progress,
+// then ready if a replica is ready and idle, busy if all of them are serving,
+// and crashed if all of them are being restarted. The caller must hold the mutex.
+func (m *Model) state() ExecutorState {
+	switch {
+	case m.loading():
+		return StateLoading
+	case m.isLoaded():
+		for _, rep := range m.replicas {
+			if rep.state() == StateReady {
+				return StateReady
+			}
+		}
+		return StateBusy
+	case len(m.replicas) > 0:
+		return StateCrashed
+	default:
+		return StateUnloaded
+	}
+}
+
+// cancelLoad cancels the loads of the model in progress or waiting
+func (m *Model) cancelLoad() {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for job := m.load; job != nil; job = job.prev {
+		if !job.finished() {
+			modelLog.Debugf("cancelling the load of %d replicas of %s", job.replicas, m.Name)
+			job.cancel()
+		}
+	}
+}
+
+// waitLoad waits for the loads of the model in progress to complete, up to the timeout.
+// It returns immediately if the model is not loading.
+func (m *Model) waitLoad(ctx context.Context, timeout time.Duration) error {
+	m.mutex.RLock()
+	job := m.load
+	m.mutex.RUnlock()
+	if job == nil || job.finished() {
+		return nil
+	}
+	modelLog.Debugf("waiting for %s to load", m.Name)
+	select {
+	case <-job.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(timeout):
+		return fmt.Errorf("%s still loading: %w after %v", m.Name, ErrTimeout, timeout)
+	}
+}
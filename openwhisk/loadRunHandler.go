@@ -20,17 +20,34 @@ package openwhisk
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
 )
 
 type requestBody struct {
 	ActionName string `json:"action_name"`
-	// Include any other fields you need
+	// ActivationID identifies the activation of /run in the log lines
+	ActivationID string `json:"activation_id,omitempty"`
+	// Replicas is the number of processes preloading the model, for /load and /scale
+	Replicas int `json:"replicas,omitempty"`
+	// TimeoutMS overrides the timeout of the model for the request, in milliseconds
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+	// Async makes /load answer as soon as the load starts
+	Async bool `json:"async,omitempty"`
+	// Cancel makes /load cancel the loads in progress
+	Cancel bool `json:"cancel,omitempty"`
+	// KeepAliveMS overrides how long the model stays loaded while idle, for /load,
+	// in milliseconds: a negative value keeps it loaded until it is offloaded
+	KeepAliveMS int `json:"keep_alive_ms,omitempty"`
 }
 
+// TimeoutHeader overrides the timeout of the model for the request, in milliseconds
+const TimeoutHeader = "X-OW-Timeout-Ms"
+
 type Data struct {
 	Content string `json:"content"`
 }
@@ -55,545 +72,146 @@ func (ap *ActionProxy) loadRunHandler(w http.ResponseWriter, r *http.Request) {
 		sendError(w, http.StatusBadRequest, fmt.Sprintf("Error reading request body: %v", err))
 		return
 	}
-	actionName := req.ActionName
-
-	if strings.Contains(actionName, "ptest04") {
-		// actionName contains "ptest"
-		Debug("LoadRunHandler done reading %d bytes", len(body))
-
-		// check if you have an action
-		if ap.theresnet18Executor == nil || ap.theresnet18Executor.cmd == nil {
-			//sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet (new)"))
-			NEWresnet18Executor1 := Newresnet18Executor(ap.outFile, ap.errFile, "_test/loadres18.sh", ap.env)
-			ap.theresnet18Executor = NEWresnet18Executor1
-			ap.runHandler(w, r)
-			return
-		}
-		if ap.theresnet18Executor.started == false {
-			Debug("Haven't pre-loaded resnet18")
-			ap.runHandler(w, r)
-			return
-		}
-
-		ap.StopAllExecutorsExcept("resnet18")
-
-		// remove newlines
-		Debug("Served By LoadRunHandler18")
-		body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
-
-		// execute the action
-		response, err := ap.theresnet18Executor.Interact(body)
-
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! RES18 Command exited！！")
-			Debug(err.Error())
-			ap.theresnet18Executor = nil
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("resnet18 command exited"))
-			sendError(w, http.StatusBadRequest, fmt.Sprintf(err.Error()))
-			return
-		}
-		DebugLimit("received:", response, 120)
-
-		// check if the answer is an object map
-		var objmap map[string]*json.RawMessage
-		resStr := strings.ReplaceAll(string(response), "'", "\"")
-		response = []byte(resStr)
-		err = json.Unmarshal(response, &objmap)
-		if err != nil {
-			sendError(w, http.StatusBadGateway, "The action did not return a dictionary.")
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
-		numBytesWritten, err := w.Write(response)
-
-		// flush output
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
-
-		// diagnostic when you have writing problems
-		if err != nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error writing response: %v", err))
-			return
-		}
-		if numBytesWritten != len(response) {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Only wrote %d of %d bytes to response", numBytesWritten, len(response)))
-			return
-		}
-	} else if strings.Contains(actionName, "ptest05") {
-		// actionName contains "ptest"
-		Debug("LoadRunHandler done reading %d bytes", len(body))
-
-		// check if you have an action
-		if ap.theresnet50Executor == nil || ap.theresnet50Executor.cmd == nil {
-			//sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet (new)"))
-			NEWresnet50Executor1 := Newresnet50Executor(ap.outFile, ap.errFile, "_test/loadres50.sh", ap.env)
-			ap.theresnet50Executor = NEWresnet50Executor1
-			ap.runHandler(w, r)
-			return
-		}
-		if ap.theresnet50Executor.started == false {
-			Debug("Haven't pre-loaded resnet50")
-			ap.runHandler(w, r)
-			return
-		}
-
-		ap.StopAllExecutorsExcept("resnet50")
-
-		Debug("Served By LoadRunHandler50")
-
-		// remove newlines
-		body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
-
-		// execute the action
-		response, err := ap.theresnet50Executor.Interact(body)
-
-		// check for early termination
-		if err != nil {
-			Debug("WARNING!!! Command exited")
-			Debug(err.Error())
-			//ap.theresnet50Executor = nil
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("resnet50 command exited"))
-			sendError(w, http.StatusBadRequest, fmt.Sprintf(err.Error()))
-			sendError(w, http.StatusBadRequest, fmt.Sprintf(string(response)))
-			return
-		}
-		DebugLimit("received:", response, 120)
-
-		// check if the answer is an object map
-		var objmap map[string]*json.RawMessage
-		resStr := strings.ReplaceAll(string(response), "'", "\"")
-		response = []byte(resStr)
-		err = json.Unmarshal(response, &objmap)
-		if err != nil {
-			sendError(w, http.StatusBadGateway, "The action did not return a dictionary.")
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
-		numBytesWritten, err := w.Write(response)
-
-		// flush output
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
-
-		// diagnostic when you have writing problems
-		if err != nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error writing response: %v", err))
-			return
-		}
-		if numBytesWritten != len(response) {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Only wrote %d of %d bytes to response", numBytesWritten, len(response)))
-			return
-		}
-	} else if strings.Contains(actionName, "ptest06") {
-		// actionName contains "ptest"
-		Debug("LoadRunHandler done reading %d bytes", len(body))
-
-		// check if you have an action
-		if ap.theresnet152Executor == nil || ap.theresnet152Executor.cmd == nil {
-			//sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet (new)"))
-			NEWresnet152Executor1 := Newresnet152Executor(ap.outFile, ap.errFile, "_test/loadres152.sh", ap.env)
-			ap.theresnet152Executor = NEWresnet152Executor1
-			ap.runHandler(w, r)
-			return
-		}
-		if ap.theresnet152Executor.started == false {
-			Debug("Haven't pre-loaded resnet152")
-			ap.runHandler(w, r)
-			return
-		}
-
-		ap.StopAllExecutorsExcept("resnet152")
-
-		Debug("Served By LoadRunHandler152")
-
-		// remove newlines
-		body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
-
-		// execute the action
-		response, err := ap.theresnet152Executor.Interact(body)
-
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited?")
-			Debug(err.Error())
-			ap.theresnet152Executor = nil
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("resnet152 command exited"))
-			sendError(w, http.StatusBadRequest, fmt.Sprintf(err.Error()))
-			return
-		}
-		DebugLimit("received:", response, 120)
-
-		// check if the answer is an object map
-		var objmap map[string]*json.RawMessage
-		resStr := strings.ReplaceAll(string(response), "'", "\"")
-		response = []byte(resStr)
-		err = json.Unmarshal(response, &objmap)
-		if err != nil {
-			sendError(w, http.StatusBadGateway, "The action did not return a dictionary.")
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
-		numBytesWritten, err := w.Write(response)
-
-		// flush output
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
-
-		// diagnostic when you have writing problems
-		if err != nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error writing response: %v", err))
-			return
-		}
-		if numBytesWritten != len(response) {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Only wrote %d of %d bytes to response", numBytesWritten, len(response)))
-			return
-		}
-	} else if strings.Contains(actionName, "ptest01") {
-		// actionName contains "ptest"
-		Debug("LoadRunHandler done reading %d bytes", len(body))
-
-		// check if you have an action
-		if ap.thealexExecutor == nil || ap.thealexExecutor.cmd == nil {
-			//sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet (new)"))
-			NEWalexExecutor1 := NewalexExecutor(ap.outFile, ap.errFile, "_test/loadalex.sh", ap.env)
-			ap.thealexExecutor = NEWalexExecutor1
-			ap.runHandler(w, r)
-			return
-		}
-		if ap.thealexExecutor.started == false {
-			Debug("Haven't pre-loaded alex")
-			ap.runHandler(w, r)
-			return
-		}
-
-		ap.StopAllExecutorsExcept("alex")
-
-		Debug("Served By LoadRunHandlerXX")
-
-		// remove newlines
-		body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
-
-		// execute the action
-		response, err := ap.thealexExecutor.Interact(body)
-
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited?")
-			Debug(err.Error())
-			ap.thealexExecutor = nil
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("alex command exited"))
-			sendError(w, http.StatusBadRequest, fmt.Sprintf(err.Error()))
-			return
-		}
-		DebugLimit("received:", response, 120)
-
-		// check if the answer is an object map
-		var objmap map[string]*json.RawMessage
-		resStr := strings.ReplaceAll(string(response), "'", "\"")
-		response = []byte(resStr)
-		err = json.Unmarshal(response, &objmap)
-		if err != nil {
-			sendError(w, http.StatusBadGateway, "The action did not return a dictionary.")
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
-		numBytesWritten, err := w.Write(response)
-
-		// flush output
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
-
-		// diagnostic when you have writing problems
-		if err != nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error writing response: %v", err))
-			return
-		}
-		if numBytesWritten != len(response) {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Only wrote %d of %d bytes to response", numBytesWritten, len(response)))
-			return
-		}
-	} else if strings.Contains(actionName, "ptest02") {
-		// actionName contains "ptest"
-		Debug("LoadRunHandler done reading %d bytes", len(body))
-
-		// check if you have an action
-		if ap.thevggExecutor == nil || ap.thevggExecutor.cmd == nil {
-			//sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet (new)"))
-			NEWvggExecutor1 := NewvggExecutor(ap.outFile, ap.errFile, "_test/loadvgg.sh", ap.env)
-			ap.thevggExecutor = NEWvggExecutor1
-			ap.runHandler(w, r)
-			return
-		}
-		if ap.thevggExecutor.started == false {
-			Debug("Haven't pre-loaded vgg")
-			ap.runHandler(w, r)
-			return
-		}
-
-		ap.StopAllExecutorsExcept("vgg")
-
-		Debug("Served By LoadRunHandlerXX")
-
-		// remove newlines
-		body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
-
-		// execute the action
-		response, err := ap.thevggExecutor.Interact(body)
-
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited?")
-			Debug(err.Error())
-			ap.thevggExecutor = nil
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("vgg command exited"))
-			sendError(w, http.StatusBadRequest, fmt.Sprintf(err.Error()))
-			return
-		}
-		DebugLimit("received:", response, 120)
-
-		// check if the answer is an object map
-		var objmap map[string]*json.RawMessage
-		resStr := strings.ReplaceAll(string(response), "'", "\"")
-		response = []byte(resStr)
-		err = json.Unmarshal(response, &objmap)
-		if err != nil {
-			sendError(w, http.StatusBadGateway, "The action did not return a dictionary.")
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
-		numBytesWritten, err := w.Write(response)
-
-		// flush output
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
-
-		// diagnostic when you have writing problems
-		if err != nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error writing response: %v", err))
-			return
-		}
-		if numBytesWritten != len(response) {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Only wrote %d of %d bytes to response", numBytesWritten, len(response)))
-			return
-		}
-	} else if strings.Contains(actionName, "ptest03") {
-		// actionName contains "ptest"
-		Debug("LoadRunHandler done reading %d bytes", len(body))
-
-		// check if you have an action
-		if ap.theinceptionExecutor == nil || ap.theinceptionExecutor.cmd == nil {
-			//sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet (new)"))
-			NEWinceptionExecutor1 := NewinceptionExecutor(ap.outFile, ap.errFile, "_test/loadinception.sh", ap.env)
-			ap.theinceptionExecutor = NEWinceptionExecutor1
-			ap.runHandler(w, r)
-			return
-		}
-		if ap.theinceptionExecutor.started == false {
-			Debug("Haven't pre-loaded inception")
-			ap.runHandler(w, r)
-			return
-		}
-
-		ap.StopAllExecutorsExcept("inception")
+	timingOf(r.Context()).mark(PhaseParsed)
 
-		Debug("Served By LoadRunHandlerXX")
-
-		// remove newlines
-		body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
-
-		// execute the action
-		response, err := ap.theinceptionExecutor.Interact(body)
-
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited?")
-			Debug(err.Error())
-			ap.theinceptionExecutor = nil
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("inception command exited"))
-			sendError(w, http.StatusBadRequest, fmt.Sprintf(err.Error()))
-			return
-		}
-		DebugLimit("received:", response, 120)
+	// the lines about the request carry the id of the activation
+	if req.ActivationID != "" {
+		r = r.WithContext(withLogger(r.Context(), requestLog(r.Context()).With("activation", req.ActivationID)))
+	}
+	m := ap.models.match(req.ActionName)
+	if m == nil {
+		ap.runHandler(w, r)
+		return
+	}
+	log := requestLog(r.Context()).With("model", m.Name)
+	log.Debugf("LoadRunHandler done reading %d bytes", len(body))
+	start := time.Now()
+	defer func() { m.stats.ran(time.Since(start)) }()
 
-		// check if the answer is an object map
-		var objmap map[string]*json.RawMessage
-		resStr := strings.ReplaceAll(string(response), "'", "\"")
-		response = []byte(resStr)
-		err = json.Unmarshal(response, &objmap)
-		if err != nil {
-			sendError(w, http.StatusBadGateway, "The action did not return a dictionary.")
-			return
-		}
+	timeout, err := requestTimeout(r, &req, m)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
-		numBytesWritten, err := w.Write(response)
+	// wait for the model being loaded rather than starting a cold run
+	err = m.waitLoad(r.Context(), timeout)
+	if err != nil {
+		ap.writeModelResponse(w, r, m, nil, err)
+		return
+	}
 
-		// flush output
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
+	m.mutex.RLock()
+	loaded := m.isLoaded()
+	m.mutex.RUnlock()
 
-		// diagnostic when you have writing problems
+	// rather than a cold run, the model is loaded specializing a stem cell
+	if !loaded && m.canSpecialize() {
+		log.Debugf("loading in a stem cell")
+		ap.startLoad(m, 1)
+		err = m.waitLoad(r.Context(), timeout)
 		if err != nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error writing response: %v", err))
-			return
-		}
-		if numBytesWritten != len(response) {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Only wrote %d of %d bytes to response", numBytesWritten, len(response)))
-			return
-		}
-	} else if strings.Contains(actionName, "ptest07") {
-		// actionName contains "ptest"
-		Debug("LoadRunHandler done reading %d bytes", len(body))
-
-		// check if you have an action
-		if ap.thegooglenetExecutor == nil || ap.thegooglenetExecutor.cmd == nil {
-			//sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet (new)"))
-			NEWgooglenetExecutor1 := NewgooglenetExecutor(ap.outFile, ap.errFile, "_test/loadgooglenet.sh", ap.env)
-			ap.thegooglenetExecutor = NEWgooglenetExecutor1
-			ap.runHandler(w, r)
-			return
-		}
-		if ap.thegooglenetExecutor.started == false {
-			Debug("Haven't pre-loaded googlenet")
-			ap.runHandler(w, r)
+			ap.writeModelResponse(w, r, m, nil, err)
 			return
 		}
+		m.mutex.RLock()
+		loaded = m.isLoaded()
+		m.mutex.RUnlock()
+	}
 
-		ap.StopAllExecutorsExcept("googlenet")
-
-		Debug("Served By LoadRunHandlerXX")
-
-		// remove newlines
-		body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
+	// without a memory budget only the model being served stays loaded
+	if loaded && ap.memoryBudget == 0 {
+		ap.StopAllExecutorsExcept(m.Name)
+	}
 
-		// execute the action
-		response, err := ap.thegooglenetExecutor.Interact(body)
+	// remove newlines
+	line := bytes.Replace(body, []byte("\n"), []byte(""), -1)
 
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited?")
-			Debug(err.Error())
-			ap.thegooglenetExecutor = nil
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("googlenet command exited"))
-			sendError(w, http.StatusBadRequest, fmt.Sprintf(err.Error()))
+	// collect the request in a batch, if the model supports them
+	var response []byte
+	err = errNotLoaded
+	if loaded && m.batcher != nil {
+		response, err = m.batcher.submit(r.Context(), line, timeout, ap.queueDepth)
+		if err == ErrQueueFull {
+			sendError(w, http.StatusTooManyRequests, fmt.Sprintf("%s cannot serve the request: %v", m.Name, err))
 			return
 		}
-		DebugLimit("received:", response, 120)
+	}
 
-		// check if the answer is an object map
-		var objmap map[string]*json.RawMessage
-		resStr := strings.ReplaceAll(string(response), "'", "\"")
-		response = []byte(resStr)
-		err = json.Unmarshal(response, &objmap)
+	if err == errNotLoaded {
+		// wait for the turn of the request
+		err = m.queue.enter(r.Context(), ap.queueDepth)
 		if err != nil {
-			sendError(w, http.StatusBadGateway, "The action did not return a dictionary.")
+			sendError(w, http.StatusTooManyRequests, fmt.Sprintf("%s cannot serve the request: %v", m.Name, err))
 			return
 		}
+		defer m.queue.leave()
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
-		numBytesWritten, err := w.Write(response)
-
-		// flush output
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
-
-		// diagnostic when you have writing problems
-		if err != nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error writing response: %v", err))
-			return
-		}
-		if numBytesWritten != len(response) {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Only wrote %d of %d bytes to response", numBytesWritten, len(response)))
-			return
-		}
-	} else if strings.Contains(actionName, "ptest08") {
-		// actionName contains "ptest"
-		Debug("LoadRunHandler done reading %d bytes", len(body))
-
-		// check if you have an action
-		if ap.thebertExecutor == nil || ap.thebertExecutor.cmd == nil {
-			//sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet (new)"))
-			NEWbertExecutor1 := NewbertExecutor(ap.outFile, ap.errFile, "_test/loadbert.sh", ap.env)
-			ap.thebertExecutor = NEWbertExecutor1
-			ap.runHandler(w, r)
-			return
-		}
-		if ap.thebertExecutor.started == false {
-			Debug("Haven't pre-loaded bert")
-			ap.runHandler(w, r)
-			return
-		}
-
-		ap.StopAllExecutorsExcept("bert")
-
-		Debug("Served By LoadRunHandlerXX")
-
-		// remove newlines
-		body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
-
-		// execute the action
-		response, err := ap.thebertExecutor.Interact(body)
-
-		// check for early termination
-		if err != nil {
-			Debug("WARNING! Command exited?")
-			Debug(err.Error())
-			ap.thebertExecutor = nil
-			sendError(w, http.StatusBadRequest, fmt.Sprintf("bert command exited"))
-			sendError(w, http.StatusBadRequest, fmt.Sprintf(err.Error()))
-			return
-		}
-		DebugLimit("received:", response, 120)
+		// execute the action on the least busy replica
+		log.Debugf("served by LoadRunHandler")
+		response, err = m.serve(r.Context(), line, timeout, 1)
+	}
 
-		// check if the answer is an object map
-		var objmap map[string]*json.RawMessage
-		resStr := strings.ReplaceAll(string(response), "'", "\"")
-		response = []byte(resStr)
-		err = json.Unmarshal(response, &objmap)
-		if err != nil {
-			sendError(w, http.StatusBadGateway, "The action did not return a dictionary.")
-			return
-		}
+	// with a cold run if the model is not loaded or was offloaded meanwhile
+	if err == errNotLoaded {
+		log.Debugf("not pre-loaded")
+		ap.runHandler(w, r)
+		return
+	}
+	// the replica crashed, retry the request with a cold run while it restarts
+	if errors.Is(err, ErrExecutorExited) && m.hasCold() {
+		log.Warnf("crashed serving the request, retrying with a cold run")
+		ap.runHandler(w, r)
+		return
+	}
+	ap.writeModelResponse(w, r, m, response, err)
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
-		numBytesWritten, err := w.Write(response)
+// writeModelResponse answers with the response of a preloaded model, or its error
+func (ap *ActionProxy) writeModelResponse(w http.ResponseWriter, r *http.Request, m *Model, response []byte, err error) {
+	log := requestLog(r.Context()).With("model", m.Name)
+	// the replica was killed and is being restarted
+	if errors.Is(err, ErrTimeout) {
+		log.Warnf("timed out")
+		sendError(w, http.StatusGatewayTimeout, fmt.Sprintf("%s: %v", m.Name, err))
+		return
+	}
+	// the replica exceeded its memory limit and is being restarted
+	if errors.Is(err, ErrOutOfMemory) {
+		log.Warnf("out of memory")
+		sendError(w, http.StatusInsufficientStorage, fmt.Sprintf("%s: %v", m.Name, err))
+		return
+	}
+	// check for early termination
+	if err != nil {
+		log.Errorf("command exited: %v", err)
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("%s command exited: %v", m.Name, err))
+		return
+	}
+	log.DebugLimit("received:", response, 120)
 
-		// flush output
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
+	writeResponse(w, response)
+}
 
-		// diagnostic when you have writing problems
+// requestTimeout is how long the model can take to answer the request:
+// the one in the header or in the body if any, else the one of the model
+func requestTimeout(r *http.Request, req *requestBody, m *Model) (time.Duration, error) {
+	ms := req.TimeoutMS
+	if header := r.Header.Get(TimeoutHeader); header != "" {
+		var err error
+		ms, err = strconv.Atoi(header)
 		if err != nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error writing response: %v", err))
-			return
+			return 0, fmt.Errorf("invalid %s header: %s", TimeoutHeader, header)
 		}
-		if numBytesWritten != len(response) {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Only wrote %d of %d bytes to response", numBytesWritten, len(response)))
-			return
-		}
-	} else {
-		ap.runHandler(w, r)
 	}
+	if ms < 0 {
+		return 0, fmt.Errorf("invalid timeout: %d ms", ms)
+	}
+	if ms > 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	if timeout := m.timeout(); timeout > 0 {
+		return timeout, nil
+	}
+	return DefaultModelTimeoutInteract, nil
 }
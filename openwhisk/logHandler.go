@@ -0,0 +1,73 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// logRequest changes the level of a subsystem, or the default one if none
+type logRequest struct {
+	Level     string `json:"level"`
+	Subsystem string `json:"subsystem,omitempty"`
+}
+
+// logHandler describes the log levels, and changes them on POST
+func (ap *ActionProxy) logHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("Error reading request body: %v", err))
+			return
+		}
+		var req logRequest
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("Error reading request body: %v", err))
+			return
+		}
+		level, err := ParseLogLevel(req.Level)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		logs.setLevel(req.Subsystem, level)
+		if req.Subsystem == "" {
+			proxyLog.Infof("log level set to %s", level)
+		} else {
+			proxyLog.Infof("log level of %s set to %s", req.Subsystem, level)
+		}
+	default:
+		sendError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s not allowed on /log", r.Method))
+		return
+	}
+	b, err := json.Marshal(logs.describe())
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+	w.Write([]byte("\n"))
+}
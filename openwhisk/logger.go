@@ -0,0 +1,367 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Debugging allows initializing the action more than once,
+// keeps the actions that fail to start and asks the actions to debug
+var Debugging = false
+
+// LogLevel is the severity of a log line
+type LogLevel int
+
+const (
+	// LevelDebug traces what the proxy does, for troubleshooting
+	LevelDebug LogLevel = iota
+	// LevelInfo reports the normal events, like starting the proxy
+	LevelInfo
+	// LevelWarn reports the failures the proxy recovers from
+	LevelWarn
+	// LevelError reports the failures of the requests
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String is the name of the level
+func (level LogLevel) String() string {
+	if level < LevelDebug || level > LevelError {
+		return strconv.Itoa(int(level))
+	}
+	return levelNames[level]
+}
+
+// ParseLogLevel parses the name of a level
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %s", name)
+}
+
+// The formats of the log lines
+const (
+	LogfmtFormat = "logfmt"
+	JSONFormat   = "json"
+)
+
+// LogSubsystems are the subsystems with their own logger
+var LogSubsystems = []string{"proxy", "http", "executor", "model", "compiler", "extractor"}
+
+// logConfig is where the loggers write and which lines they write:
+// the level of each subsystem, or the default one
+type logConfig struct {
+	mutex  sync.RWMutex
+	out    io.Writer
+	json   bool
+	level  LogLevel
+	levels map[string]LogLevel
+}
+
+func newLogConfig(out io.Writer) *logConfig {
+	return &logConfig{sync.RWMutex{}, out, false, LevelWarn, map[string]LogLevel{}}
+}
+
+// logs is the configuration of the loggers of the proxy
+var logs = newLogConfig(os.Stderr)
+
+// the loggers of the subsystems
+var (
+	proxyLog     = logs.logger("proxy")
+	httpLog      = logs.logger("http")
+	executorLog  = logs.logger("executor")
+	modelLog     = logs.logger("model")
+	compilerLog  = logs.logger("compiler")
+	extractorLog = logs.logger("extractor")
+)
+
+// NewLogger returns the logger of a subsystem
+func NewLogger(subsystem string) *Logger {
+	return logs.logger(subsystem)
+}
+
+// SetLogLevel sets the level of the given subsystem, or the default one if empty,
+// which applies to the subsystems without their own
+func SetLogLevel(subsystem string, level LogLevel) {
+	logs.setLevel(subsystem, level)
+}
+
+// SetLogFormat selects the format of the lines, logfmt or json
+func SetLogFormat(format string) error {
+	return logs.setFormat(format)
+}
+
+// SetLogOutput redirects the log lines
+func SetLogOutput(out io.Writer) {
+	logs.mutex.Lock()
+	defer logs.mutex.Unlock()
+	logs.out = out
+}
+
+// ToggleDebugOnSignal switches the default level to debug on SIGUSR1,
+// and back to the previous level on the next SIGUSR1
+func ToggleDebugOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		previous := LevelWarn
+		for range ch {
+			current, _ := logs.levelOf("")
+			if current == LevelDebug {
+				logs.setLevel("", previous)
+			} else {
+				previous = current
+				logs.setLevel("", LevelDebug)
+			}
+			level, _ := logs.levelOf("")
+			proxyLog.Infof("log level set to %s", level)
+		}
+	}()
+}
+
+func (c *logConfig) logger(subsystem string) *Logger {
+	return &Logger{c, subsystem, nil}
+}
+
+func (c *logConfig) setLevel(subsystem string, level LogLevel) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if subsystem == "" {
+		c.level = level
+		return
+	}
+	c.levels[subsystem] = level
+}
+
+// levelOf returns the level of the subsystem, or the default one,
+// and if the subsystem has its own
+func (c *logConfig) levelOf(subsystem string) (LogLevel, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if level, ok := c.levels[subsystem]; ok {
+		return level, true
+	}
+	return c.level, false
+}
+
+func (c *logConfig) setFormat(format string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch format {
+	case LogfmtFormat:
+		c.json = false
+	case JSONFormat:
+		c.json = true
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	return nil
+}
+
+// field is a key and a value attached to a logger
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes leveled structured lines for a subsystem,
+// with the fields attached to it
+type Logger struct {
+	config    *logConfig
+	subsystem string
+	fields    []field
+}
+
+// With returns a logger adding the field to its lines
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := append(append([]field{}, l.fields...), field{key, value})
+	return &Logger{l.config, l.subsystem, fields}
+}
+
+// Subsystem returns a logger of another subsystem with the same fields,
+// to report about a request in the subsystem serving it
+func (l *Logger) Subsystem(subsystem string) *Logger {
+	return &Logger{l.config, subsystem, l.fields}
+}
+
+// Enabled checks if the lines of the given level are written
+func (l *Logger) Enabled(level LogLevel) bool {
+	min, _ := l.config.levelOf(l.subsystem)
+	return level >= min
+}
+
+// Debugf writes a debug line
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.write(LevelDebug, format, args...)
+}
+
+// Infof writes an info line
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.write(LevelInfo, format, args...)
+}
+
+// Warnf writes a warning line
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.write(LevelWarn, format, args...)
+}
+
+// Errorf writes an error line
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.write(LevelError, format, args...)
+}
+
+// DebugLimit writes a debug line with the payload, truncated to the limit
+func (l *Logger) DebugLimit(msg string, in []byte, limit int) {
+	if !l.Enabled(LevelDebug) {
+		return
+	}
+	if len(in) < limit {
+		l.Debugf("%s %s", msg, in)
+	} else {
+		l.Debugf("%s %s...", msg, in[0:limit])
+	}
+}
+
+func (l *Logger) write(level LogLevel, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	fields := append([]field{
+		{"time", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"level", level.String()},
+		{"subsystem", l.subsystem},
+	}, l.fields...)
+	fields = append(fields, field{"msg", fmt.Sprintf(format, args...)})
+
+	l.config.mutex.RLock()
+	defer l.config.mutex.RUnlock()
+	var line []byte
+	if l.config.json {
+		line = jsonLine(fields)
+	} else {
+		line = logfmtLine(fields)
+	}
+	l.config.out.Write(line)
+}
+
+// logfmtLine formats the fields as key=value pairs, quoting the values when needed
+func logfmtLine(fields []field) []byte {
+	var buf bytes.Buffer
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		value := fmt.Sprint(f.value)
+		if value == "" || strings.ContainsAny(value, " =\"\\\n\t") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(f.key)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// jsonLine formats the fields as a JSON object, in order
+func jsonLine(fields []field) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// envNames lists the names of the variables of an environment,
+// as their values can be secrets
+func envNames(env map[string]string) string {
+	names := []string{}
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+// loggerKey is the key of the logger of a request in its context
+type loggerKey struct{}
+
+// withLogger attaches the logger to the context of a request
+func withLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// requestLog returns the logger of the request, carrying its ids,
+// or the http logger if none
+func requestLog(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return httpLog
+}
+
+// logLevels describes the levels, for /log
+type logLevels struct {
+	Level      string            `json:"level"`
+	Subsystems map[string]string `json:"subsystems,omitempty"`
+}
+
+// describe describes the default level and the ones of the subsystems having their own
+func (c *logConfig) describe() logLevels {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	res := logLevels{c.level.String(), map[string]string{}}
+	names := []string{}
+	for name := range c.levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		res.Subsystems[name] = c.levels[name].String()
+	}
+	return res
+}
@@ -0,0 +1,135 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	config := newLogConfig(&buf)
+	exec := config.logger("executor")
+	compiler := config.logger("compiler")
+
+	exec.Infof("hidden")
+	exec.Warnf("shown")
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), "level=warn subsystem=executor msg=shown")
+
+	// the level of a subsystem overrides the default one
+	config.setLevel("executor", LevelDebug)
+	buf.Reset()
+	exec.Debugf("now shown")
+	compiler.Debugf("hidden")
+	assert.Contains(t, buf.String(), `msg="now shown"`)
+	assert.NotContains(t, buf.String(), "compiler")
+
+	// setting the default one keeps the ones of the subsystems
+	config.setLevel("", LevelError)
+	buf.Reset()
+	compiler.Warnf("hidden")
+	compiler.Errorf("failed")
+	exec.Debugf("still shown")
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), "level=error subsystem=compiler")
+	assert.Contains(t, buf.String(), "level=debug subsystem=executor")
+}
+
+func TestLoggerFormats(t *testing.T) {
+	var buf bytes.Buffer
+	config := newLogConfig(&buf)
+	l := config.logger("http").With("request", "r1").With("activation", "a b")
+
+	l.Warnf("hello %s", "world")
+	assert.Contains(t, buf.String(), `subsystem=http request=r1 activation="a b" msg="hello world"`)
+
+	assert.NotNil(t, config.setFormat("xml"))
+	assert.Nil(t, config.setFormat(JSONFormat))
+	buf.Reset()
+	l.Subsystem("executor").Warnf("hello")
+	var line map[string]string
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "warn", line["level"])
+	assert.Equal(t, "executor", line["subsystem"])
+	assert.Equal(t, "r1", line["request"])
+	assert.Equal(t, "a b", line["activation"])
+	assert.Equal(t, "hello", line["msg"])
+}
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("WARN")
+	assert.Nil(t, err)
+	assert.Equal(t, LevelWarn, level)
+	_, err = ParseLogLevel("verbose")
+	assert.NotNil(t, err)
+	assert.Equal(t, "7", LogLevel(7).String())
+}
+
+func TestRequestLog(t *testing.T) {
+	assert.Equal(t, httpLog, requestLog(context.Background()))
+	l := httpLog.With("request", "1")
+	assert.Equal(t, l, requestLog(withLogger(context.Background(), l)))
+}
+
+func TestEnvNames(t *testing.T) {
+	assert.Equal(t, "A B", envNames(map[string]string{"B": "secret", "A": "secret"}))
+}
+
+func TestLogHandler(t *testing.T) {
+	resetLevels := func(level LogLevel) {
+		logs.mutex.Lock()
+		defer logs.mutex.Unlock()
+		logs.level, logs.levels = level, map[string]LogLevel{}
+	}
+	resetLevels(LevelInfo)
+	defer resetLevels(LevelWarn)
+	ts := httptest.NewServer(NewActionProxy("./action/to", "", nil, nil))
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/log", "application/json", strings.NewReader(`{"level":"debug","subsystem":"executor"}`))
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, `{"level":"info","subsystems":{"executor":"debug"}}`+"\n", string(body))
+	assert.True(t, executorLog.Enabled(LevelDebug))
+	assert.False(t, compilerLog.Enabled(LevelDebug))
+
+	res, _ = http.Post(ts.URL+"/log", "application/json", strings.NewReader(`{"level":"loud"}`))
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, _ = http.Post(ts.URL+"/log", "application/json", strings.NewReader(`{"level":"warn"}`))
+	res.Body.Close()
+	res, _ = http.Get(ts.URL + "/log")
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, `{"level":"warn","subsystems":{"executor":"debug"}}`+"\n", string(body))
+	assert.True(t, executorLog.Enabled(LevelDebug))
+	assert.False(t, compilerLog.Enabled(LevelInfo))
+}
@@ -0,0 +1,166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const megabyte = 1 << 20
+
+// RSSSampleInterval is how often the resident memory of the loaded models is sampled,
+// with a memory budget, to track the peak memory of each model
+var RSSSampleInterval = time.Second
+
+// SetMemoryBudget sets the total resident memory, in megabytes, the preloaded models can use.
+// With a budget several models are kept loaded, evicting the least recently used ones when needed.
+// Without a budget (0) only the model being served is kept loaded.
+// The models must be set before.
+func (ap *ActionProxy) SetMemoryBudget(mb uint64) {
+	ap.memoryBudget = mb * megabyte
+	if ap.memoryBudget > 0 {
+		ap.samplingRSS.Do(func() {
+			go ap.sampleRSS()
+		})
+	}
+}
+
+// sampleRSS measures the loaded models periodically, out of the path of the requests,
+// as a measure scans all the processes
+func (ap *ActionProxy) sampleRSS() {
+	for range time.Tick(RSSSampleInterval) {
+		ap.usedMemory(nil)
+	}
+}
+
+// rss measures the resident memory of the process groups of the replicas of the model,
+// the caller must hold the mutex
+func (m *Model) rss() uint64 {
+	var total uint64
+	for _, rep := range m.replicas {
+		if rep.executor.State() != StateReady {
+			continue
+		}
+		rss := groupRSS(rep.executor.Pid())
+		total += rss
+		for {
+			peak := atomic.LoadUint64(&m.peakRSS)
+			if rss <= peak || atomic.CompareAndSwapUint64(&m.peakRSS, peak, rss) {
+				break
+			}
+		}
+	}
+	return total
+}
+
+// expectedMemory is the memory a replica of the model needs:
+// the declared one, or the highest measured if bigger
+func (m *Model) expectedMemory() uint64 {
+	need := m.MemoryMB * megabyte
+	if peak := atomic.LoadUint64(&m.peakRSS); peak > need {
+		need = peak
+	}
+	return need
+}
+
+// touch records the model was used now
+func (m *Model) touch() {
+	atomic.StoreInt64(&m.lastUsed, time.Now().UnixNano())
+}
+
+// usedMemory measures the memory used by the loaded models but the given one
+func (ap *ActionProxy) usedMemory(except *Model) uint64 {
+	var used uint64
+	for _, m := range ap.models.all() {
+		if m != except {
+			m.mutex.RLock()
+			used += m.rss()
+			m.mutex.RUnlock()
+		}
+	}
+	return used
+}
+
+// leastRecentlyUsed returns the loaded model used least recently but the given one
+func (ap *ActionProxy) leastRecentlyUsed(except *Model) *Model {
+	var lru *Model
+	var lruTime int64
+	for _, m := range ap.models.all() {
+		if m == except {
+			continue
+		}
+		m.mutex.RLock()
+		loaded := m.isLoaded()
+		m.mutex.RUnlock()
+		used := atomic.LoadInt64(&m.lastUsed)
+		if loaded && (lru == nil || used < lruTime) {
+			lru, lruTime = m, used
+		}
+	}
+	return lru
+}
+
+// makeRoom evicts the least recently used models until the given number of replicas
+// of the model fits in the memory budget, and reserves the memory they need.
+// The caller must release the reserved memory once the replicas are started, or failed.
+// If they cannot fit it returns the http status to answer with and the reason.
+// The caller must not hold the mutex of any model, as evicting waits for the runs in progress.
+func (ap *ActionProxy) makeRoom(m *Model, replicas int) (uint64, int, error) {
+	if ap.memoryBudget == 0 {
+		return 0, http.StatusOK, nil
+	}
+	need := m.expectedMemory() * uint64(replicas)
+	if need > ap.memoryBudget {
+		return 0, http.StatusInsufficientStorage,
+			fmt.Errorf("model %s needs %d MB, more than the memory budget of %d MB", m.Name, need/megabyte, ap.memoryBudget/megabyte)
+	}
+	// the check and the reservation are atomic across the models
+	ap.budgetMutex.Lock()
+	defer ap.budgetMutex.Unlock()
+	for {
+		used := ap.usedMemory(m) + ap.reservedMemory
+		if used+need <= ap.memoryBudget {
+			ap.reservedMemory += need
+			return need, http.StatusOK, nil
+		}
+		lru := ap.leastRecentlyUsed(m)
+		if lru == nil {
+			free := uint64(0)
+			if used < ap.memoryBudget {
+				free = ap.memoryBudget - used
+			}
+			return 0, http.StatusConflict,
+				fmt.Errorf("model %s needs %d MB but only %d MB of the memory budget are free", m.Name, need/megabyte, free/megabyte)
+		}
+		modelLog.With("model", m.Name).Infof("evicting %s to make room", lru.Name)
+		lru.stop()
+	}
+}
+
+// release frees the memory reserved by makeRoom
+func (ap *ActionProxy) release(reserved uint64) {
+	if reserved == 0 {
+		return
+	}
+	ap.budgetMutex.Lock()
+	ap.reservedMemory -= reserved
+	ap.budgetMutex.Unlock()
+}
@@ -0,0 +1,104 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadProcStat(t *testing.T) {
+	stat, err := readProcStat(os.Getpid())
+	assert.Nil(t, err)
+	assert.Equal(t, os.Getppid(), stat.ppid)
+	assert.True(t, stat.rss > 0)
+}
+
+func TestMakeRoom_evictLRU(t *testing.T) {
+	ap := NewActionProxy("./action/mb", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "one", Action: "one", Load: "_test/model.sh", Cold: "_test/model.sh", MemoryMB: 4},
+		{Name: "two", Action: "two", Load: "_test/model.sh", Cold: "_test/model.sh", MemoryMB: 4},
+		{Name: "big", Action: "big", Load: "_test/model.sh", Cold: "_test/model.sh", MemoryMB: 10},
+	})
+	ap.SetMemoryBudget(5)
+	one := ap.models.get("one")
+	two := ap.models.get("two")
+
+	// the first model fits
+	reserved, status, err := ap.makeRoom(one, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, one.scale(ap.env, 1))
+	ap.release(reserved)
+	one.touch()
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, one.rss() > 0)
+
+	// the second evicts the first
+	reserved, status, err = ap.makeRoom(two, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, one.replicas)
+	ap.release(reserved)
+
+	// two replicas of the second do not fit
+	_, status, err = ap.makeRoom(two, 2)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusInsufficientStorage, status)
+
+	// too big for the budget
+	_, status, err = ap.makeRoom(ap.models.get("big"), 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusInsufficientStorage, status)
+}
+
+func TestMakeRoom_concurrent(t *testing.T) {
+	ap := NewActionProxy("./action/mb", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "one", Action: "one", Load: "_test/model.sh", MemoryMB: 4},
+		{Name: "two", Action: "two", Load: "_test/model.sh", MemoryMB: 4},
+	})
+	ap.SetMemoryBudget(5)
+
+	// only one of the models fits, the other cannot evict it while it loads
+	statuses := make(chan int, 2)
+	reservations := make(chan uint64, 2)
+	for _, name := range []string{"one", "two"} {
+		go func(m *Model) {
+			reserved, status, _ := ap.makeRoom(m, 1)
+			reservations <- reserved
+			statuses <- status
+		}(ap.models.get(name))
+	}
+	codes := []int{<-statuses, <-statuses}
+	assert.ElementsMatch(t, []int{http.StatusOK, http.StatusConflict}, codes)
+	reserved := <-reservations + <-reservations
+	assert.Equal(t, uint64(4*megabyte), reserved)
+
+	// the memory is free again once released
+	ap.release(reserved)
+	reserved, status, err := ap.makeRoom(ap.models.get("two"), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	ap.release(reserved)
+	assert.Equal(t, uint64(0), ap.reservedMemory)
+}
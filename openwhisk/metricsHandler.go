@@ -0,0 +1,119 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metricsContentType is the version of the Prometheus text format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// labelEscaper escapes the values of the labels
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsHandler answers with the counters and the histograms of the models
+// in the Prometheus text format
+func (ap *ActionProxy) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "metrics can only be read with GET")
+		return
+	}
+	stats := map[string]*modelStats{}
+	names := []string{}
+	for _, m := range ap.models.all() {
+		stats[m.Name] = m.stats.snapshot()
+		names = append(names, m.Name)
+	}
+
+	var buf bytes.Buffer
+	counters := func(name string, help string, value func(s *modelStats) uint64) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, model := range names {
+			fmt.Fprintf(&buf, "%s{model=\"%s\"} %d\n", name, labelEscaper.Replace(model), value(stats[model]))
+		}
+	}
+	histograms := func(name string, help string, value func(s *modelStats) *histogram) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, model := range names {
+			writeHistogram(&buf, name, fmt.Sprintf("model=\"%s\"", labelEscaper.Replace(model)), value(stats[model]))
+		}
+	}
+
+	name := "openwhisk_runs_total"
+	fmt.Fprintf(&buf, "# HELP %s Activations served, warm by a loaded replica or cold by a cold run.\n# TYPE %s counter\n", name, name)
+	for _, model := range names {
+		label := labelEscaper.Replace(model)
+		fmt.Fprintf(&buf, "%s{model=\"%s\",mode=\"warm\"} %d\n", name, label, stats[model].warm)
+		fmt.Fprintf(&buf, "%s{model=\"%s\",mode=\"cold\"} %d\n", name, label, stats[model].cold)
+	}
+	counters("openwhisk_loads_total", "Loads starting replicas of the model.",
+		func(s *modelStats) uint64 { return s.loads })
+	counters("openwhisk_offloads_total", "Offloads of the model, stopping all its replicas.",
+		func(s *modelStats) uint64 { return s.offloads })
+	counters("openwhisk_executor_crashes_total", "Processes of the model terminated on their own.",
+		func(s *modelStats) uint64 { return s.crashes })
+	counters("openwhisk_timeouts_total", "Loads and requests of the model which timed out.",
+		func(s *modelStats) uint64 { return s.timeouts })
+	counters("openwhisk_oom_kills_total", "Processes of the model killed for exceeding their memory.",
+		func(s *modelStats) uint64 { return s.oomKills })
+	histograms("openwhisk_load_duration_seconds", "Time to start the replicas of a load.",
+		func(s *modelStats) *histogram { return &s.loadTime })
+	histograms("openwhisk_inference_duration_seconds", "Time for the processes of the model to answer, warm and cold.",
+		func(s *modelStats) *histogram { return &s.inference })
+	histograms("openwhisk_run_duration_seconds", "Time to answer the /run requests, from end to end.",
+		func(s *modelStats) *histogram { return &s.runs })
+
+	name = "openwhisk_phase_duration_seconds"
+	fmt.Fprintf(&buf, "# HELP %s Time of the phases reported by the processes of the model.\n# TYPE %s histogram\n", name, name)
+	for _, model := range names {
+		phases := []string{}
+		for phase := range stats[model].phases {
+			phases = append(phases, phase)
+		}
+		sort.Strings(phases)
+		for _, phase := range phases {
+			labels := fmt.Sprintf("model=\"%s\",phase=\"%s\"", labelEscaper.Replace(model), labelEscaper.Replace(phase))
+			writeHistogram(&buf, name, labels, stats[model].phases[phase])
+		}
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Write(buf.Bytes())
+}
+
+// writeHistogram writes the cumulative buckets, the sum and the count of the histogram,
+// with the given labels
+func writeHistogram(buf *bytes.Buffer, name string, labels string, h *histogram) {
+	var cumulative uint64
+	for i, bound := range MetricsBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, le, cumulative)
+	}
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.count)
+}
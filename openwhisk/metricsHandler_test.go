@@ -0,0 +1,126 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getMetrics reads /metrics, mapping each sample to its value
+func getMetrics(t *testing.T, ts *httptest.Server) map[string]string {
+	res, err := http.Get(ts.URL + "/metrics")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, metricsContentType, res.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(res.Body)
+	samples := map[string]string{}
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		samples[line[:i]] = line[i+1:]
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "fake", Action: "/guest/fake", Load: "_test/loading.sh", Cold: "_test/cold.sh", Ack: true,
+			Env: map[string]string{"LOAD_DELAY": "0"}},
+		{Name: "crash", Action: "/guest/crash", Load: "_test/crash.sh", Cold: "_test/cold.sh", MaxRestarts: -1},
+		{Name: "stuck", Action: "/guest/stuck", Load: "_test/loading.sh", Cold: "_test/cold.sh", Ack: true,
+			LoadTimeoutMS: 100, Env: map[string]string{"LOAD_DELAY": "5"}},
+	})
+	ap.SetMemoryBudget(1024)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/fake"}`)
+	assert.Equal(t, http.StatusOK, status)
+	for i := 0; i < 2; i++ {
+		_, status, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/fake","value":{}}`)
+		assert.Equal(t, http.StatusOK, status)
+	}
+	_, status, _ = doPost(ts.URL+"/offload", `{"action_name":"/guest/fake"}`)
+	assert.Equal(t, http.StatusOK, status)
+	_, status, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/crash"}`)
+	assert.Equal(t, http.StatusOK, status)
+	doPost(ts.URL+"/run", `{"action_name":"/guest/crash","value":{"crash":true}}`)
+	_, status, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/stuck"}`)
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	time.Sleep(100 * time.Millisecond)
+
+	samples := getMetrics(t, ts)
+	assert.Equal(t, "2", samples[`openwhisk_runs_total{model="fake",mode="warm"}`])
+	assert.Equal(t, "0", samples[`openwhisk_runs_total{model="fake",mode="cold"}`])
+	assert.Equal(t, "1", samples[`openwhisk_loads_total{model="fake"}`])
+	assert.Equal(t, "1", samples[`openwhisk_offloads_total{model="fake"}`])
+	assert.Equal(t, "1", samples[`openwhisk_load_duration_seconds_count{model="fake"}`])
+	assert.Equal(t, "2", samples[`openwhisk_inference_duration_seconds_count{model="fake"}`])
+	assert.Equal(t, "2", samples[`openwhisk_inference_duration_seconds_bucket{model="fake",le="+Inf"}`])
+	assert.Equal(t, "2", samples[`openwhisk_run_duration_seconds_count{model="fake"}`])
+	assert.Equal(t, "1", samples[`openwhisk_executor_crashes_total{model="crash"}`])
+	assert.Equal(t, "0", samples[`openwhisk_executor_crashes_total{model="fake"}`])
+	assert.Equal(t, "1", samples[`openwhisk_timeouts_total{model="stuck"}`])
+	assert.Equal(t, "0", samples[`openwhisk_loads_total{model="stuck"}`])
+
+	_, status, _ = doPost(ts.URL+"/metrics", `{}`)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func Example_writeHistogram() {
+	h := histogram{}
+	for _, d := range []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second} {
+		h.observe(d)
+	}
+	var buf bytes.Buffer
+	writeHistogram(&buf, "latency_seconds", `model="fake"`, &h)
+	fmt.Print(buf.String())
+	// Output:
+	// latency_seconds_bucket{model="fake",le="0.005"} 0
+	// latency_seconds_bucket{model="fake",le="0.01"} 0
+	// latency_seconds_bucket{model="fake",le="0.025"} 0
+	// latency_seconds_bucket{model="fake",le="0.05"} 0
+	// latency_seconds_bucket{model="fake",le="0.1"} 0
+	// latency_seconds_bucket{model="fake",le="0.25"} 1
+	// latency_seconds_bucket{model="fake",le="0.5"} 2
+	// latency_seconds_bucket{model="fake",le="1"} 2
+	// latency_seconds_bucket{model="fake",le="2.5"} 3
+	// latency_seconds_bucket{model="fake",le="5"} 3
+	// latency_seconds_bucket{model="fake",le="10"} 3
+	// latency_seconds_bucket{model="fake",le="30"} 3
+	// latency_seconds_bucket{model="fake",le="60"} 3
+	// latency_seconds_bucket{model="fake",le="120"} 3
+	// latency_seconds_bucket{model="fake",le="300"} 3
+	// latency_seconds_bucket{model="fake",le="+Inf"} 3
+	// latency_seconds_sum{model="fake"} 2.75
+	// latency_seconds_count{model="fake"} 3
+}
@@ -0,0 +1,161 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ModelCatalog is the content of the file declaring the preloadable models
+type ModelCatalog struct {
+	Models []ModelSpec `json:"models"`
+}
+
+// LoadModelCatalog reads the model catalog in the given JSON file
+// and validates it, returning all the problems found
+func LoadModelCatalog(file string) ([]ModelSpec, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read model catalog: %v", err)
+	}
+	var catalog ModelCatalog
+	err = json.Unmarshal(buf, &catalog)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse model catalog %s: %v", file, err)
+	}
+	err = ValidateModels(catalog.Models)
+	if err != nil {
+		return nil, fmt.Errorf("invalid model catalog %s: %v", file, err)
+	}
+	return catalog.Models, nil
+}
+
+// ValidateModels checks the model specs are complete,
+// their patterns compile and their scripts are executable
+func ValidateModels(specs []ModelSpec) error {
+	errs := []string{}
+	names := map[string]bool{}
+	for i, spec := range specs {
+		if spec.Name == "" {
+			errs = append(errs, fmt.Sprintf("model #%d: missing name", i+1))
+			continue
+		}
+		if names[spec.Name] {
+			errs = append(errs, fmt.Sprintf("model %s: duplicate name", spec.Name))
+		}
+		names[spec.Name] = true
+		if spec.Action == "" && spec.Pattern == "" {
+			errs = append(errs, fmt.Sprintf("model %s: either action or pattern is required", spec.Name))
+		}
+		if spec.Pattern != "" {
+			if _, err := regexp.Compile(spec.Pattern); err != nil {
+				errs = append(errs, fmt.Sprintf("model %s: bad pattern: %v", spec.Name, err))
+			}
+		}
+		if spec.Dir != "" {
+			if info, err := os.Stat(spec.Dir); err != nil || !info.IsDir() {
+				errs = append(errs, fmt.Sprintf("model %s: dir %s is not a directory", spec.Name, spec.Dir))
+			}
+		}
+		if spec.BatchWindowMS < 0 || spec.MaxBatch < 0 {
+			errs = append(errs, fmt.Sprintf("model %s: negative batch_window_ms or max_batch", spec.Name))
+		}
+		if spec.Protocol < 0 || spec.Protocol > ProtocolV2 {
+			errs = append(errs, fmt.Sprintf("model %s: unsupported protocol %d", spec.Name, spec.Protocol))
+		}
+		if spec.CPUs < 0 || spec.PidsMax < 0 {
+			errs = append(errs, fmt.Sprintf("model %s: negative cpus or pids_max", spec.Name))
+		}
+		if err := spec.checkCPUs(); err != nil {
+			errs = append(errs, fmt.Sprintf("model %s: %v", spec.Name, err))
+		}
+		if spec.HealthIntervalMS < 0 {
+			errs = append(errs, fmt.Sprintf("model %s: negative health_interval_ms", spec.Name))
+		}
+		if spec.TimeoutMS < 0 || spec.RestartBackoffMS < 0 || spec.LoadTimeoutMS < 0 || spec.KeepAliveMS < 0 || spec.StopGraceMS < 0 {
+			errs = append(errs, fmt.Sprintf("model %s: negative timeout_ms, load_timeout_ms, keep_alive_ms, stop_grace_ms or restart_backoff_ms", spec.Name))
+		}
+		if spec.Entrypoint != "" && !spec.Zygote && !spec.Stem {
+			errs = append(errs, fmt.Sprintf("model %s: entrypoint requires zygote or stem", spec.Name))
+		}
+		if spec.Simulate != nil {
+			if err := spec.Simulate.check(); err != nil {
+				errs = append(errs, fmt.Sprintf("model %s: simulate: %v", spec.Name, err))
+			}
+			if spec.Zygote || spec.Stem {
+				errs = append(errs, fmt.Sprintf("model %s: a simulated model cannot use the zygote or the stem cells", spec.Name))
+			}
+			continue
+		}
+		if err := checkExecutable(spec.Dir, spec.Load); err != nil {
+			errs = append(errs, fmt.Sprintf("model %s: load command %v", spec.Name, err))
+		}
+		if err := checkExecutable(spec.Dir, spec.Cold); err != nil {
+			errs = append(errs, fmt.Sprintf("model %s: cold command %v", spec.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// checkExecutable checks the command, relative to dir if not absolute, is an executable file
+func checkExecutable(dir string, command string) error {
+	if command == "" {
+		return fmt.Errorf("is missing")
+	}
+	if !strings.Contains(command, "/") {
+		if _, err := exec.LookPath(command); err != nil {
+			return fmt.Errorf("%s not found in PATH", command)
+		}
+		return nil
+	}
+	path := command
+	if !filepath.IsAbs(path) && dir != "" {
+		path = filepath.Join(dir, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s not found", command)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", command)
+	}
+	return nil
+}
+
+// SetModels replaces the models the proxy is able to preload,
+// stopping the executors of the current ones
+func (ap *ActionProxy) SetModels(specs []ModelSpec) {
+	ap.StopAllExecutorsExcept("")
+	ap.models = newModelRegistry(specs, ap.outFile, ap.errFile)
+	if ap.zygote != nil {
+		ap.models.useZygote(ap.zygote)
+	}
+	if ap.stemCells != nil {
+		ap.models.useStemCells(ap.stemCells)
+	}
+}
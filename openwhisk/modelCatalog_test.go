@@ -0,0 +1,52 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadModelCatalog(t *testing.T) {
+	specs, err := LoadModelCatalog("_test/models/models.json")
+	assert.Nil(t, err)
+	reg := newModelRegistry(specs, nil, nil)
+	assert.Equal(t, "fake", reg.match("/guest/fake").Name)
+	assert.Equal(t, "fakes", reg.match("/guest/fake10").Name)
+	assert.Nil(t, reg.match("/guest/fake10x"))
+	assert.Nil(t, reg.match("/guest/fakes"))
+	assert.Equal(t, "fakes", reg.get("fakes").environment(map[string]string{"A": "B"})["MODEL"])
+}
+
+func ExampleLoadModelCatalog_errors() {
+	_, err := LoadModelCatalog("_test/models/bad.json")
+	fmt.Println(err)
+	_, err = LoadModelCatalog("_test/models/donotexist.json")
+	fmt.Println(err)
+	// Output:
+	// invalid model catalog _test/models/bad.json: model missing: load command _test/donotexist.sh not found; model noexec: bad pattern: error parsing regexp: missing closing ): `(`; model noexec: load command _test/models/models.json is not executable; model nomatch: either action or pattern is required
+	// cannot read model catalog: open _test/models/donotexist.json: no such file or directory
+}
+
+func TestDefaultModels_exactMatch(t *testing.T) {
+	reg := newModelRegistry(DefaultModels, nil, nil)
+	assert.Equal(t, "alex", reg.match("/guest/ptest01").Name)
+	assert.Nil(t, reg.match("/guest/ptest010"))
+}
@@ -0,0 +1,729 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultModelTimeoutStart is how long a model executor is watched for an early exit
+var DefaultModelTimeoutStart = 100 * time.Millisecond
+
+// DefaultModelTimeoutLoad is how long a model executor can take to acknowledge it is loaded
+var DefaultModelTimeoutLoad = 5 * time.Minute
+
+// DefaultModelTimeoutInteract is how long a preloaded model can take to answer
+var DefaultModelTimeoutInteract = 15 * time.Second
+
+// ErrExecutorExited is returned when the process of a model terminates while serving a request
+var ErrExecutorExited = errors.New("command exited")
+
+// ErrTimeout is returned when a model does not answer in time
+var ErrTimeout = errors.New("timed out")
+
+// ErrAborted is returned by Start when the loading was aborted
+var ErrAborted = errors.New("loading aborted")
+
+// ExecutorState is the lifecycle state of a model executor
+type ExecutorState string
+
+const (
+	// StateUnloaded means the process has not been started yet or was stopped
+	StateUnloaded ExecutorState = "unloaded"
+	// StateLoading means the model is being loaded
+	StateLoading ExecutorState = "loading"
+	// StateReady means the process is running and can accept requests
+	StateReady ExecutorState = "ready"
+	// StateBusy means the process is serving a request
+	StateBusy ExecutorState = "busy"
+	// StateCrashed means the process terminated on its own
+	StateCrashed ExecutorState = "crashed"
+)
+
+// ModelExecutor is the guardian of a process serving a model.
+// A preloading executor keeps the model in memory and answers
+// one line for each line of input it receives.
+type ModelExecutor interface {
+	Start(waitForAck bool, timeout time.Duration) error
+	// Interact sends a request and waits for its answer up to the timeout.
+	// The context carries the timing of the request, it does not interrupt it,
+	// as a late answer would be read by the next request.
+	Interact(ctx context.Context, in []byte, timeout time.Duration) ([]byte, error)
+	// Stop terminates the process gracefully, killing it after the grace period
+	Stop()
+	// Abort interrupts a Start in progress, killing the process;
+	// it can be called at any time, also concurrently with Start
+	Abort()
+	State() ExecutorState
+	Pid() int
+	// Done is closed when the process terminates
+	Done() <-chan bool
+	// ExitStatus describes how the process terminated, empty while it runs
+	ExitStatus() string
+}
+
+// modelExecutor is the process based implementation of ModelExecutor,
+// used both for preloaded models and for cold runs of a model
+type modelExecutor struct {
+	name   string
+	cmd    *exec.Cmd
+	input  io.WriteCloser
+	output *bufio.Reader
+	// pipeOut and pipeIn are the ends of the result pipe,
+	// pipeIn is passed to the process as file descriptor 3
+	pipeOut *os.File
+	pipeIn  *os.File
+	exited  chan bool
+	// aborted is closed to interrupt the loading
+	aborted   chan struct{}
+	abortOnce sync.Once
+	// exit describes how the process terminated, set before exited is closed
+	exit  string
+	group bool
+	// grace is how long the process can take to terminate when stopped, 0 for DefaultStopGrace
+	grace time.Duration
+	// limits bound the resources of the processes, nil for none, with cgroup if available
+	limits *resourceLimits
+	cgroup *cgroup
+	// cgroupMutex protects cgroup, as a stem cell is limited once specialized
+	cgroupMutex sync.Mutex
+	// oom is set before exited is closed if the process was killed for exceeding its memory
+	oom bool
+	// crashed is set before exited is closed if the process terminated on its own,
+	// rather than being stopped or killed by the proxy
+	crashed bool
+	// zygote forks the process when set, instead of starting the command
+	zygote *zygote
+	// entrypoint is the python script the zygote runs instead of executing the command, if any
+	entrypoint string
+	// pid is the pid of the process once started
+	pid int
+	// started is accessed atomically, as it is checked during interactions
+	started int32
+	// mutex serializes the exchanges with the process
+	mutex sync.Mutex
+	// protocol is the version requested until the acknowledgement, then the negotiated one
+	protocol int
+	// lastID is the id of the last request sent with protocol version 2
+	lastID uint64
+	// onPhase records the phases reported by the process, nil to ignore them
+	onPhase func(name string, d time.Duration)
+}
+
+// NewModelExecutor creates a child subprocess serving the model with the given name,
+// writing the logs in the given files and the results in file descriptor 3.
+// If group is true the process is started in its own process group,
+// so that stopping it also terminates the processes it spawned.
+func NewModelExecutor(logout *os.File, logerr *os.File, name string, group bool, command string, env map[string]string, args ...string) *modelExecutor {
+	cmd := exec.Command(command, args...)
+	if group {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	if logout != nil {
+		cmd.Stdout = logout
+	}
+	if logerr != nil {
+		cmd.Stderr = logerr
+	}
+	cmd.Env = []string{}
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	executorLog.Debugf("env of %s: %s", name, envNames(env))
+	if Debugging {
+		cmd.Env = append(cmd.Env, "OW_DEBUG=/tmp/action.log")
+	}
+
+	// stdin is a plain pipe, as it can be passed to a zygote too
+	stdin, input, err := os.Pipe()
+	if err != nil {
+		executorLog.Debugf("%s executor input meets an error: %v", name, err)
+		return nil
+	}
+	cmd.Stdin = stdin
+	pipeOut, pipeIn, err := os.Pipe()
+	if err != nil {
+		executorLog.Debugf("%s executor output meets an error: %v", name, err)
+		return nil
+	}
+	cmd.ExtraFiles = []*os.File{pipeIn}
+
+	return &modelExecutor{
+		name,
+		cmd,
+		input,
+		bufio.NewReader(pipeOut),
+		pipeOut,
+		pipeIn,
+		make(chan bool),
+		make(chan struct{}),
+		sync.Once{},
+		"",
+		group,
+		0,
+		nil,
+		nil,
+		sync.Mutex{},
+		false,
+		false,
+		nil,
+		"",
+		0,
+		0,
+		sync.Mutex{},
+		requestedProtocol(env),
+		0,
+		nil,
+	}
+}
+
+// Start starts the command and waits for it to be ready to accept input.
+// If waitForAck is true, it waits for an acknowledgement from the command.
+// If waitForAck is false, it waits for a short time to check if the command has exited.
+// Requesting protocol version 2 requires an acknowledgement, confirming the version:
+// if it is not required otherwise, a command not acknowledging within DefaultTimeoutAck
+// is spoken to with version 1.
+// A command not acknowledging within the timeout, DefaultModelTimeoutLoad if 0, is killed.
+func (proc *modelExecutor) Start(waitForAck bool, timeout time.Duration) error {
+	executorLog.Debugf("Start loading %s (pre-load):", proc.name)
+	if proc.cmd == nil {
+		return fmt.Errorf("%s executor already stopped", proc.name)
+	}
+	select {
+	case <-proc.aborted:
+		return ErrAborted
+	default:
+	}
+	proc.setStarted(true)
+	wait, err := proc.startProcess()
+	if err != nil {
+		executorLog.Warnf("cannot start %s: %v", proc.name, err)
+		proc.cmd = nil // no need to kill
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+
+	go func(name string, pid int) {
+		proc.reaped(wait())
+		executorLog.Debugf("%s pid %d terminated: %s", name, pid, proc.exit)
+		close(proc.exited)
+	}(proc.name, proc.pid)
+	return proc.ready(waitForAck, timeout)
+}
+
+// ready waits for the started process to be ready to accept input,
+// as described in Start
+func (proc *modelExecutor) ready(waitForAck bool, timeout time.Duration) error {
+	fallback := false
+	if proc.protocol > ProtocolV1 && !waitForAck {
+		waitForAck = true
+		fallback = true
+	}
+	if !waitForAck {
+		select {
+		case <-proc.exited:
+			return fmt.Errorf("command exited")
+		case <-proc.aborted:
+			proc.setStarted(false)
+			proc.kill()
+			return ErrAborted
+		case <-time.After(DefaultModelTimeoutStart):
+			return nil
+		}
+	}
+
+	// wait for acknowledgement
+	if fallback {
+		proc.pipeOut.SetReadDeadline(time.Now().Add(DefaultTimeoutAck))
+	}
+	ack := make(chan error, 1)
+	protocol := make(chan int, 1)
+	go func() {
+		out, err := proc.readAnswer(nil)
+		if fallback {
+			proc.pipeOut.SetReadDeadline(time.Time{})
+			if errors.Is(err, os.ErrDeadlineExceeded) && len(out) == 0 {
+				executorLog.Warnf("%s: no acknowledgement of protocol version %d after %v, speaking version %d", proc.name, proc.protocol, DefaultTimeoutAck, ProtocolV1)
+				ack <- nil
+				protocol <- ProtocolV1
+				return
+			}
+		}
+		if err != nil {
+			ack <- err
+			return
+		}
+		var ackData ActionAck
+		err = json.Unmarshal(out, &ackData)
+		if err != nil {
+			ack <- err
+			return
+		}
+		if !ackData.Ok {
+			ack <- fmt.Errorf("The action did not initialize properly.")
+			return
+		}
+		ack <- nil
+		protocol <- negotiatedProtocol(proc.protocol, ackData)
+	}()
+
+	if timeout <= 0 {
+		timeout = DefaultModelTimeoutLoad
+	}
+	select {
+	case err := <-ack:
+		if err == nil {
+			proc.protocol = <-protocol
+		}
+		return err
+	case <-proc.exited:
+		return fmt.Errorf("command exited abruptly during initialization")
+	case <-proc.aborted:
+		proc.setStarted(false)
+		proc.kill()
+		return ErrAborted
+	case <-time.After(timeout):
+		proc.setStarted(false)
+		proc.kill()
+		return fmt.Errorf("%s load %w after %v", proc.name, ErrTimeout, timeout)
+	}
+}
+
+// Interact sends a line to the preloaded model and waits for a line of answer,
+// up to the timeout or DefaultModelTimeoutInteract if it is 0.
+// When the timeout expires the process is killed, as its late answer
+// would be read by the next request, and it is not ready anymore.
+func (proc *modelExecutor) Interact(ctx context.Context, in []byte, timeout time.Duration) ([]byte, error) {
+	proc.mutex.Lock()
+	defer proc.mutex.Unlock()
+	if !proc.isStarted() {
+		return nil, fmt.Errorf("%s executor is not running", proc.name)
+	}
+	timing := timingOf(ctx)
+	if proc.protocol == ProtocolV2 {
+		return proc.interactV2(timing, in, timeout)
+	}
+	_, err := proc.input.Write(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write to stdin: %w", err)
+	}
+	_, err = proc.input.Write([]byte("\n"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write newline to stdin: %w", err)
+	}
+
+	type reply struct {
+		out []byte
+		err error
+	}
+	chout := make(chan reply, 1)
+	go func() {
+		// the answer can be longer than the buffer of the reader
+		line, err := proc.readAnswer(timing)
+		chout <- reply{bytes.TrimRight(line, "\r\n"), err}
+	}()
+
+	if timeout <= 0 {
+		timeout = DefaultModelTimeoutInteract
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-chout:
+		if len(r.out) == 0 {
+			// the output is closed when the process is terminating
+			select {
+			case <-proc.exited:
+				proc.setStarted(false)
+				return nil, proc.exitError()
+			case <-time.After(DefaultModelTimeoutStart):
+			}
+			if r.err != nil {
+				return nil, fmt.Errorf("no answer from the %s action: %v", proc.name, r.err)
+			}
+			return nil, fmt.Errorf("no answer from the %s action", proc.name)
+		}
+		return r.out, nil
+	case <-proc.exited:
+		proc.setStarted(false)
+		return nil, proc.exitError()
+	case <-timer.C:
+		proc.setStarted(false)
+		proc.kill()
+		return nil, fmt.Errorf("%s operation %w after %v", proc.name, ErrTimeout, timeout)
+	}
+}
+
+// interactV2 sends a request framed with protocol version 2 and waits for the answer with the same id,
+// the caller must hold the mutex
+func (proc *modelExecutor) interactV2(timing *runTiming, in []byte, timeout time.Duration) ([]byte, error) {
+	proc.lastID++
+	id := proc.lastID
+	if err := writeFrame(proc.input, id, in); err != nil {
+		return nil, fmt.Errorf("failed to write to stdin: %w", err)
+	}
+
+	type reply struct {
+		out []byte
+		err error
+	}
+	chout := make(chan reply, 1)
+	go func() {
+		out, err := readReply(proc.output, id, proc.name, func(payload []byte) {
+			proc.reportPhase(timing, payload)
+		})
+		if err == nil {
+			timing.mark(PhaseFirstByte)
+		}
+		chout <- reply{out, err}
+	}()
+
+	if timeout <= 0 {
+		timeout = DefaultModelTimeoutInteract
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-chout:
+		if r.err == io.EOF {
+			// the output is closed when the process is terminating
+			select {
+			case <-proc.exited:
+				proc.setStarted(false)
+				return nil, proc.exitError()
+			case <-time.After(DefaultModelTimeoutStart):
+			}
+		}
+		if r.err != nil {
+			// the stream cannot be trusted anymore
+			proc.setStarted(false)
+			proc.kill()
+			return nil, fmt.Errorf("%s: %v", proc.name, r.err)
+		}
+		return r.out, nil
+	case <-proc.exited:
+		proc.setStarted(false)
+		return nil, proc.exitError()
+	case <-timer.C:
+		proc.setStarted(false)
+		proc.kill()
+		return nil, fmt.Errorf("%s operation %w after %v", proc.name, ErrTimeout, timeout)
+	}
+}
+
+// StartAndWaitForOutput performs a cold run: it starts the command
+// and waits for the first line it writes, up to the timeout or DefaultModelTimeoutInteract if it is 0,
+// killing the command if it expires. The context carries the timing of the request.
+func (proc *modelExecutor) StartAndWaitForOutput(ctx context.Context, timeout time.Duration) ([]byte, error) {
+	timing := timingOf(ctx)
+	if proc.cmd == nil {
+		return nil, fmt.Errorf("%s executor already stopped", proc.name)
+	}
+	proc.setStarted(true)
+	wait, err := proc.startProcess()
+	if err != nil {
+		executorLog.Debugf("run: early exit")
+		proc.cmd = nil // no need to kill
+		proc.pipeOut.Close()
+		proc.setStarted(false)
+		return nil, fmt.Errorf("command exited")
+	}
+
+	timing.mark(PhaseSpawned)
+
+	// the answer is read until the process closes the result pipe
+	type reply struct {
+		out []byte
+		err error
+	}
+	chout := make(chan reply, 1)
+	go func() {
+		out, err := proc.readAnswer(timing)
+		chout <- reply{out, err}
+	}()
+	read := make(chan struct{})
+	go func() {
+		proc.reaped(wait())
+		if cg := proc.limitedBy(); cg != nil {
+			cg.remove()
+		}
+		// the answer can still be in the pipe when the process terminates
+		<-read
+		proc.pipeOut.Close()
+		close(proc.exited)
+	}()
+
+	if timeout <= 0 {
+		timeout = DefaultModelTimeoutInteract
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var out []byte
+	select {
+	case r := <-chout:
+		out, err = r.out, r.err
+		close(read)
+	case <-timer.C:
+		proc.setStarted(false)
+		proc.kill()
+		// the pipe can be held open by the processes the command spawned
+		proc.pipeOut.Close()
+		close(read)
+		return nil, fmt.Errorf("%s cold run %w after %v", proc.name, ErrTimeout, timeout)
+	}
+	if err != nil || len(out) == 0 {
+		err = errors.New("no answer from the action")
+		select {
+		case <-proc.exited:
+			if proc.oom {
+				err = ErrOutOfMemory
+			}
+		case <-time.After(DefaultModelTimeoutStart):
+		}
+	}
+	proc.setStarted(false)
+	return out, err
+}
+
+// startProcess starts the command, or has the zygote fork it if any,
+// within the resource limits, applied by the process before running the command.
+// It returns a function waiting for the termination of the process
+// and describing how it terminated. A zygote that cannot fork the process is bypassed.
+func (proc *modelExecutor) startProcess() (func() string, error) {
+	limits := proc.prepareLimits()
+	// late are the limits applied once the process started, when it cannot apply them
+	var late *childLimits
+	var wait func() string
+	if proc.zygote != nil {
+		pid, status, err := proc.zygote.spawn(proc.cmd, proc.entrypoint, proc.group, limits)
+		if err == nil {
+			proc.pid = pid
+			wait = func() string { return <-status }
+		} else {
+			executorLog.Debugf("%s not forked, starting it: %v", proc.name, err)
+		}
+	}
+	var err error
+	if wait == nil {
+		if limits != nil {
+			if err := limitCommand(proc.cmd, limits); err != nil {
+				executorLog.Warnf("cannot limit %s before it starts: %v", proc.name, err)
+				late = limits
+			}
+		}
+		if err = startWithAffinity(proc.cmd, proc.cpuset()); err == nil {
+			cmd := proc.cmd
+			proc.pid = cmd.Process.Pid
+			wait = func() string {
+				cmd.Wait()
+				return exitStatus(cmd.ProcessState)
+			}
+		}
+	}
+	// the process has its own copies of stdin and of the result pipe:
+	// closing ours lets reading the result end when the process terminates
+	if stdin, ok := proc.cmd.Stdin.(*os.File); ok {
+		stdin.Close()
+	}
+	proc.pipeIn.Close()
+	if err != nil {
+		if cg := proc.limitedBy(); cg != nil {
+			cg.remove()
+			proc.setCgroup(nil)
+		}
+		return nil, err
+	}
+	executorLog.Debugf("%s pid: %d", proc.name, proc.pid)
+	proc.limit(late)
+	return wait, nil
+}
+
+func (proc *modelExecutor) setStarted(started bool) {
+	var v int32
+	if started {
+		v = 1
+	}
+	atomic.StoreInt32(&proc.started, v)
+}
+
+func (proc *modelExecutor) isStarted() bool {
+	return atomic.LoadInt32(&proc.started) == 1
+}
+
+// Exited checks if the underlying command exited
+func (proc *modelExecutor) Exited() bool {
+	select {
+	case <-proc.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel closed when the process terminates
+func (proc *modelExecutor) Done() <-chan bool {
+	return proc.exited
+}
+
+// State returns the lifecycle state of the process.
+// A terminated process is crashed if it was not stopped or killed by the proxy,
+// as decided when it was reaped.
+func (proc *modelExecutor) State() ExecutorState {
+	if proc.Exited() {
+		if proc.crashed {
+			return StateCrashed
+		}
+		return StateUnloaded
+	}
+	if proc.cmd == nil || !proc.isStarted() {
+		return StateUnloaded
+	}
+	return StateReady
+}
+
+// Pid returns the pid of the process, 0 if it is not running
+func (proc *modelExecutor) Pid() int {
+	if proc.cmd == nil {
+		return 0
+	}
+	return proc.pid
+}
+
+// Stop terminates the process, and its whole process group if it was started in one,
+// with SIGTERM and then SIGKILL if it does not terminate within the grace period
+func (proc *modelExecutor) Stop() {
+	executorLog.Debugf("stopping %s", proc.name)
+	proc.setStarted(false)
+	terminate(proc.name, proc.pid, proc.group, proc.exited, proc.grace)
+	if cg := proc.limitedBy(); cg != nil {
+		cg.remove()
+	}
+	// the stdin of a process never started is still ours
+	if proc.cmd != nil {
+		if stdin, ok := proc.cmd.Stdin.(*os.File); ok {
+			stdin.Close()
+		}
+	}
+	proc.cmd = nil
+	proc.input.Close()
+	proc.pipeIn.Close()
+	proc.pipeOut.Close()
+	runtime.GC()
+}
+
+// Abort interrupts the loading of the process, if in progress
+func (proc *modelExecutor) Abort() {
+	proc.abortOnce.Do(func() { close(proc.aborted) })
+}
+
+// kill kills the process, and its whole process group
+// if it was started in one, unless it already exited
+func (proc *modelExecutor) kill() {
+	if proc.cmd == nil || proc.pid == 0 || proc.Exited() {
+		return
+	}
+	signalProcess(proc.pid, proc.group, syscall.SIGKILL)
+}
+
+// prepareLimits creates the cgroup of the process about to start, if any, returning
+// the limits the process applies to itself before running its command, nil for none
+func (proc *modelExecutor) prepareLimits() *childLimits {
+	if proc.limits == nil {
+		return nil
+	}
+	cg, limits, err := proc.limits.prepare(proc.name)
+	if err != nil {
+		executorLog.Warnf("cannot limit %s: %v", proc.name, err)
+	}
+	proc.setCgroup(cg)
+	return limits
+}
+
+// limit restricts the running process, and the processes of its group, to the cpuset,
+// applying them the limits if not nil
+func (proc *modelExecutor) limit(limits *childLimits) {
+	if proc.limits == nil {
+		return
+	}
+	if err := proc.limits.apply(proc.name, proc.pid, proc.group, limits); err != nil {
+		executorLog.Warnf("cannot limit %s: %v", proc.name, err)
+	}
+}
+
+// setCgroup sets the cgroup of the process, nil if none
+func (proc *modelExecutor) setCgroup(cg *cgroup) {
+	proc.cgroupMutex.Lock()
+	proc.cgroup = cg
+	proc.cgroupMutex.Unlock()
+}
+
+// cpuset returns the cpus the process is limited to, nil for all
+func (proc *modelExecutor) cpuset() cpuSet {
+	if proc.limits == nil {
+		return nil
+	}
+	return proc.limits.cpuset
+}
+
+// limitedBy returns the cgroup of the process, nil if none
+func (proc *modelExecutor) limitedBy() *cgroup {
+	proc.cgroupMutex.Lock()
+	defer proc.cgroupMutex.Unlock()
+	return proc.cgroup
+}
+
+// reaped records how the process terminated, once it was reaped
+func (proc *modelExecutor) reaped(status string) {
+	proc.exit = status
+	// the proxy marks the process as not started before stopping or killing it
+	proc.crashed = proc.isStarted()
+	if cg := proc.limitedBy(); cg != nil && cg.oomKilled() {
+		proc.oom = true
+		proc.exit = oomStatus + ", " + proc.exit
+	}
+}
+
+// exitError is the error of an interaction with the terminated process
+func (proc *modelExecutor) exitError() error {
+	if proc.Exited() && proc.oom {
+		return ErrOutOfMemory
+	}
+	return ErrExecutorExited
+}
+
+// ExitStatus describes how the process terminated, empty while it runs
+func (proc *modelExecutor) ExitStatus() string {
+	if !proc.Exited() {
+		return ""
+	}
+	return proc.exit
+}
@@ -0,0 +1,396 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ModelSpec describes a model the proxy is able to preload
+type ModelSpec struct {
+	// Name of the model, the key in the registry
+	Name string `json:"name"`
+	// Action is the exact action name served by the model
+	Action string `json:"action,omitempty"`
+	// Pattern is a regular expression matching the action names served by the model
+	Pattern string `json:"pattern,omitempty"`
+	// Load is the command preloading the model and serving requests,
+	// not needed for a simulated model
+	Load string `json:"load"`
+	// Cold is the command performing a single cold run of the model,
+	// not needed for a simulated model
+	Cold string `json:"cold"`
+	// Args are passed to both the load and the cold command
+	Args []string `json:"args,omitempty"`
+	// Dir is the working directory of the commands
+	Dir string `json:"dir,omitempty"`
+	// Env is added to the environment of the commands
+	Env map[string]string `json:"env,omitempty"`
+	// MemoryMB is the expected resident memory of the loaded model, in megabytes
+	MemoryMB uint64 `json:"memory_mb,omitempty"`
+	// Batch declares the load command accepts a JSON array of requests in a line
+	// and answers with a JSON array of answers, in the same order
+	Batch bool `json:"batch,omitempty"`
+	// BatchWindowMS is how long requests are collected in a batch, in milliseconds
+	BatchWindowMS int `json:"batch_window_ms,omitempty"`
+	// MaxBatch is the largest number of requests in a batch
+	MaxBatch int `json:"max_batch,omitempty"`
+	// MaxRestarts is how many times in a row a crashed replica is restarted,
+	// 0 means DefaultMaxRestarts and a negative value disables the restarts
+	MaxRestarts int `json:"max_restarts,omitempty"`
+	// RestartBackoffMS is the delay before the first restart, in milliseconds,
+	// 0 means DefaultRestartBackoff
+	RestartBackoffMS int `json:"restart_backoff_ms,omitempty"`
+	// TimeoutMS is how long a replica can take to answer a request, in milliseconds,
+	// 0 means DefaultModelTimeoutInteract
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+	// Protocol is the version of the action loop protocol requested to the load command,
+	// 0 for version 1
+	Protocol int `json:"protocol,omitempty"`
+	// HealthIntervalMS is how often idle replicas are pinged, in milliseconds, 0 disables the pings
+	HealthIntervalMS int `json:"health_interval_ms,omitempty"`
+	// Ack declares the load command writes {"ok":true} when the model is loaded,
+	// so loading completes only then
+	Ack bool `json:"ack,omitempty"`
+	// LoadTimeoutMS is how long the load command can take to acknowledge, in milliseconds,
+	// 0 means DefaultModelTimeoutLoad
+	LoadTimeoutMS int `json:"load_timeout_ms,omitempty"`
+	// KeepAliveMS is how long the model stays loaded while idle, in milliseconds,
+	// 0 keeps it loaded until it is offloaded
+	KeepAliveMS int `json:"keep_alive_ms,omitempty"`
+	// StopGraceMS is how long the load command can take to terminate after SIGTERM,
+	// in milliseconds, before it is killed; 0 means DefaultStopGrace
+	StopGraceMS int `json:"stop_grace_ms,omitempty"`
+	// MemoryMaxMB limits the memory of each process group of the model, in megabytes
+	MemoryMaxMB uint64 `json:"memory_max_mb,omitempty"`
+	// CPUs limits the cpus each process group of the model can use, in cpus
+	CPUs float64 `json:"cpus,omitempty"`
+	// PidsMax limits the number of processes in each process group of the model
+	PidsMax int `json:"pids_max,omitempty"`
+	// CPUSet restricts the processes of the model to a list of cpus and ranges of cpus, like 0-3,6
+	CPUSet string `json:"cpuset,omitempty"`
+	// Threads sets the threads of the numeric libraries, OMP_NUM_THREADS and the like,
+	// 0 means one for each cpu of the cpuset, if any
+	Threads int `json:"threads,omitempty"`
+	// Zygote declares the commands can be forked by the zygote of the proxy, if any,
+	// instead of being started from scratch
+	Zygote bool `json:"zygote,omitempty"`
+	// Entrypoint is the python script the zygote or the stem cell runs for the load command
+	// in its own interpreter, with the arguments of the command, instead of executing the command
+	Entrypoint string `json:"entrypoint,omitempty"`
+	// Stem declares the load command can be run by a stem cell of the proxy, if any,
+	// specialized in the model instead of starting the command
+	Stem bool `json:"stem,omitempty"`
+	// Simulate imitates the model with the proxy itself instead of running the load and cold commands,
+	// to exercise the proxy without the libraries of the model
+	Simulate *SimulatedModel `json:"simulate,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// DefaultModels are the models known to the proxy
+// when no catalog is provided, matched in this order
+var DefaultModels = []ModelSpec{
+	{Name: "resnet18", Pattern: `^(.*/)?ptest04$`, Load: "_test/loadres18.sh", Cold: "_test/func18.sh"},
+	{Name: "resnet50", Pattern: `^(.*/)?ptest05$`, Load: "_test/loadres50.sh", Cold: "_test/func50.sh"},
+	{Name: "resnet152", Pattern: `^(.*/)?ptest06$`, Load: "_test/loadres152.sh", Cold: "_test/func152.sh"},
+	{Name: "alex", Pattern: `^(.*/)?ptest01$`, Load: "_test/loadalex.sh", Cold: "_test/funcalex.sh"},
+	{Name: "vgg", Pattern: `^(.*/)?ptest02$`, Load: "_test/loadvgg.sh", Cold: "_test/funcvgg.sh"},
+	{Name: "inception", Pattern: `^(.*/)?ptest03$`, Load: "_test/loadinception.sh", Cold: "_test/funcinception.sh"},
+	{Name: "googlenet", Pattern: `^(.*/)?ptest07$`, Load: "_test/loadgooglenet.sh", Cold: "_test/funcgooglenet.sh"},
+	{Name: "bert", Pattern: `^(.*/)?ptest08$`, Load: "_test/loadbert.sh", Cold: "_test/funcbert.sh"},
+}
+
+// matches checks if the action name is served by the model
+func (spec *ModelSpec) matches(actionName string) bool {
+	if spec.Action != "" && spec.Action == actionName {
+		return true
+	}
+	return spec.regex != nil && spec.regex.MatchString(actionName)
+}
+
+// batching checks if the requests to the model are collected in batches:
+// the load command must support them and batching must be configured
+func (spec *ModelSpec) batching() bool {
+	return spec.Batch && spec.BatchWindowMS > 0 && spec.MaxBatch > 1
+}
+
+// environment merges the model environment over the given one.
+// The protocol requested to the action does not apply to the model,
+// which can request its own, and the threads of the model
+// apply unless its environment sets them.
+func (spec *ModelSpec) environment(env map[string]string) map[string]string {
+	res := map[string]string{}
+	for k, v := range env {
+		res[k] = v
+	}
+	delete(res, ProtocolEnv)
+	if spec.Protocol > 0 {
+		res[ProtocolEnv] = strconv.Itoa(spec.Protocol)
+	}
+	if threads := spec.threads(); threads > 0 {
+		for _, k := range threadEnvs {
+			res[k] = strconv.Itoa(threads)
+		}
+	}
+	for k, v := range spec.Env {
+		res[k] = v
+	}
+	return res
+}
+
+// Model is an entry of the registry: the spec of a model
+// with the pool of replicas preloading it and the executor for the next cold run.
+// The fields accessed atomically come first to be 64-bit aligned.
+type Model struct {
+	// lastUsed is when the model was last loaded or served a request, in unix nanoseconds
+	lastUsed int64
+	// peakRSS is the highest resident memory measured for the model
+	peakRSS uint64
+	// keepAlive is how long the model stays loaded while idle, in nanoseconds, 0 for ever
+	keepAlive int64
+	// coldRunning counts the cold runs in progress
+	coldRunning int32
+
+	ModelSpec
+	// mutex protects the executors: runs hold it for reading,
+	// changing the replicas holds it for writing
+	mutex    sync.RWMutex
+	replicas []*replica
+	// load is the last load requested, nil if none
+	load *loadJob
+	// reaper offloads the model when idle for keepAlive, reaperGen identifies the current one
+	reaper    *time.Timer
+	reaperGen int
+	cold      *modelExecutor
+	// queue orders the requests to the model, letting one request
+	// for each ready replica use the model at the same time
+	queue *requestQueue
+	// batcher collects the requests in batches, nil if batching is disabled
+	batcher *batcher
+	// stats counts the requests served
+	stats modelStats
+	// limits bound the resources of each executor, nil for none
+	limits *resourceLimits
+	// zygote forks the processes of the model, nil to start them
+	zygote *zygote
+	// stemCells are specialized in the model when it is loaded, nil for none
+	stemCells *stemPool
+	// outFile and errFile receive the logs of the executors
+	outFile *os.File
+	errFile *os.File
+}
+
+func newModel(spec ModelSpec) *Model {
+	m := &Model{ModelSpec: spec, queue: newRequestQueue()}
+	m.setKeepAlive(0)
+	if spec.limited() {
+		cpuset, _ := parseCPUSet(spec.CPUSet)
+		m.limits = &resourceLimits{spec.MemoryMaxMB * megabyte, spec.CPUs, spec.PidsMax, cpuset, systemCgroupParent()}
+	}
+	if spec.batching() {
+		m.batcher = newBatcher(m, time.Duration(spec.BatchWindowMS)*time.Millisecond, spec.MaxBatch)
+	} else if spec.BatchWindowMS > 0 {
+		modelLog.Debugf("%s does not declare batch support, serving single requests", spec.Name)
+	}
+	return m
+}
+
+// isLoaded checks if at least a replica of the model is ready to serve,
+// the caller must hold the mutex
+func (m *Model) isLoaded() bool {
+	return m.replicaCount() > 0
+}
+
+// stop cancels the loads in progress and stops all the replicas of the model
+func (m *Model) stop() {
+	m.cancelLoad()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.scale(nil, 0)
+}
+
+// newExecutor creates a fresh preloading executor for the model
+func (m *Model) newExecutor(env map[string]string) ModelExecutor {
+	command, env, args := m.command(m.Load, false, m.environment(env))
+	proc := NewModelExecutor(m.outFile, m.errFile, m.Name, true, command, env, args...)
+	if proc == nil {
+		return nil
+	}
+	proc.cmd.Dir = m.Dir
+	proc.grace = time.Duration(m.StopGraceMS) * time.Millisecond
+	proc.limits = m.limits
+	proc.zygote = m.zygote
+	proc.entrypoint = m.Entrypoint
+	proc.onPhase = m.stats.phase
+	return proc
+}
+
+// newColdExecutor creates a fresh executor for a cold run of the model
+func (m *Model) newColdExecutor(env map[string]string) *modelExecutor {
+	command, env, args := m.command(m.Cold, true, m.environment(env))
+	proc := NewModelExecutor(m.outFile, m.errFile, m.Name, false, command, env, args...)
+	if proc != nil {
+		proc.cmd.Dir = m.Dir
+		proc.limits = m.limits
+		proc.zygote = m.zygote
+		proc.onPhase = m.stats.phase
+	}
+	return proc
+}
+
+// hasCold checks if the model has an executor for cold runs
+func (m *Model) hasCold() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.cold != nil
+}
+
+// coldRun serves a request starting the cold command of the model,
+// after preparing a new executor for the next cold run.
+// The command runs without holding the mutex, up to the timeout of the request,
+// the default one if 0. The lines about the request are written with the logger of the context.
+func (m *Model) coldRun(ctx context.Context, env map[string]string, timeout time.Duration) ([]byte, error) {
+	log := requestLog(ctx).Subsystem("executor").With("model", m.Name)
+	m.mutex.Lock()
+	cold := m.cold
+	if cold == nil {
+		m.mutex.Unlock()
+		return nil, fmt.Errorf("%s has no cold executor", m.Name)
+	}
+	m.cold = m.newColdExecutor(env)
+	m.mutex.Unlock()
+	atomic.AddInt32(&m.coldRunning, 1)
+	defer atomic.AddInt32(&m.coldRunning, -1)
+	log.Debugf("starting a cold run")
+	timingOf(ctx).choose(PathCold)
+	start := time.Now()
+	response, err := cold.StartAndWaitForOutput(ctx, timeout)
+	if err != nil {
+		log.Warnf("cold run failed: %v", err)
+	}
+	m.stats.recordCold(time.Since(start), err)
+	m.guard(1)
+	return response, err
+}
+
+// guard terminates the logs of the given number of activations
+func (m *Model) guard(activations int) {
+	for i := 0; i < activations; i++ {
+		if m.outFile != nil {
+			m.outFile.Write([]byte(OutputGuard))
+		}
+		if m.errFile != nil {
+			m.errFile.Write([]byte(OutputGuard))
+		}
+	}
+}
+
+// modelRegistry holds the models, keyed by name
+type modelRegistry struct {
+	models map[string]*Model
+	order  []string
+}
+
+// newModelRegistry creates a registry for the given specs,
+// whose executors write the logs in the given files
+func newModelRegistry(specs []ModelSpec, outFile *os.File, errFile *os.File) *modelRegistry {
+	reg := &modelRegistry{map[string]*Model{}, []string{}}
+	for _, spec := range specs {
+		if spec.Pattern != "" {
+			spec.regex, _ = regexp.Compile(spec.Pattern)
+		}
+		m := newModel(spec)
+		m.outFile, m.errFile = outFile, errFile
+		reg.models[spec.Name] = m
+		reg.order = append(reg.order, spec.Name)
+	}
+	return reg
+}
+
+// get returns the model with the given name, or nil
+func (reg *modelRegistry) get(name string) *Model {
+	return reg.models[name]
+}
+
+// match returns the model serving the action, or nil
+func (reg *modelRegistry) match(actionName string) *Model {
+	for _, name := range reg.order {
+		m := reg.models[name]
+		if m.matches(actionName) {
+			return m
+		}
+	}
+	return nil
+}
+
+// all returns the models in registration order
+func (reg *modelRegistry) all() []*Model {
+	res := make([]*Model, 0, len(reg.order))
+	for _, name := range reg.order {
+		res = append(res, reg.models[name])
+	}
+	return res
+}
+
+// useZygote has the models declaring it forked by the given zygote, or started if nil;
+// the executors already created are not affected
+func (reg *modelRegistry) useZygote(z *zygote) {
+	for _, m := range reg.all() {
+		if !m.Zygote {
+			continue
+		}
+		m.mutex.Lock()
+		m.zygote = z
+		m.mutex.Unlock()
+	}
+}
+
+// useStemCells has the models declaring it loaded specializing the stem cells of the pool,
+// or starting their commands if nil
+func (reg *modelRegistry) useStemCells(pool *stemPool) {
+	for _, m := range reg.all() {
+		if !m.Stem {
+			continue
+		}
+		m.mutex.Lock()
+		m.stemCells = pool
+		m.mutex.Unlock()
+	}
+}
+
+// prepare creates the executors for the next cold run of each model,
+// releasing the pipes of the ones they replace, never started
+func (reg *modelRegistry) prepare(env map[string]string) {
+	for _, m := range reg.all() {
+		m.mutex.Lock()
+		old := m.cold
+		m.cold = m.newColdExecutor(env)
+		m.mutex.Unlock()
+		if old != nil {
+			old.Stop()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelRegistry_match(t *testing.T) {
+	reg := newModelRegistry(DefaultModels, nil, nil)
+	assert.Equal(t, "resnet50", reg.match("/guest/ptest05").Name)
+	assert.Equal(t, "bert", reg.match("/guest/ptest08").Name)
+	assert.Nil(t, reg.match("/guest/hello"))
+	assert.Equal(t, len(DefaultModels), len(reg.all()))
+}
+
+func TestModelRegistry_patterns(t *testing.T) {
+	reg := newModelRegistry(DefaultModels, nil, nil)
+	assert.Equal(t, "resnet18", reg.match("ptest04").Name)
+	assert.Nil(t, reg.match("/guest/ptest04x"))
+	assert.Nil(t, reg.match("/guest/xptest04"))
+	assert.Nil(t, reg.match("/guest/ptest04/x"))
+}
+
+func TestModelRegistry_prepare(t *testing.T) {
+	reg := newModelRegistry([]ModelSpec{
+		{Name: "one", Action: "one", Load: "_test/model.sh", Cold: "_test/model.sh"},
+	}, nil, nil)
+	env := map[string]string{}
+	reg.prepare(env)
+	fds, _ := ioutil.ReadDir("/proc/self/fd")
+	// preparing again releases the pipes of the replaced executors
+	for i := 0; i < 10; i++ {
+		reg.prepare(env)
+	}
+	again, _ := ioutil.ReadDir("/proc/self/fd")
+	assert.True(t, len(again) <= len(fds))
+	assert.True(t, reg.get("one").hasCold())
+}
+
+func TestModelRegistry_stopAll(t *testing.T) {
+	reg := newModelRegistry([]ModelSpec{
+		{Name: "one", Action: "one", Load: "_test/model.sh", Cold: "_test/model.sh"},
+		{Name: "two", Action: "two", Load: "_test/model.sh", Cold: "_test/model.sh"},
+	}, nil, nil)
+	ap := NewActionProxy("./action/mr", "", nil, nil)
+	ap.models = reg
+	for _, m := range reg.all() {
+		assert.Nil(t, m.scale(ap.env, 1))
+		assert.Equal(t, StateReady, m.replicas[0].executor.State())
+	}
+	ap.StopAllExecutorsExcept("one")
+	assert.True(t, reg.get("one").isLoaded())
+	assert.Empty(t, reg.get("two").replicas)
+	ap.StopAllExecutorsExcept("none")
+	assert.Empty(t, reg.get("one").replicas)
+}
+
+func TestModel_logs(t *testing.T) {
+	log, _ := ioutil.TempFile("", "log")
+	defer os.Remove(log.Name())
+	reg := newModelRegistry([]ModelSpec{
+		{Name: "fake", Action: "fake", Load: "_test/model.sh", Cold: "_test/cold.sh"},
+	}, log, log)
+	reg.prepare(map[string]string{})
+	m := reg.get("fake")
+
+	// the results are read from file descriptor 3, the logs are kept apart
+	res, err := m.coldRun(context.Background(), map[string]string{}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"model\": \"cold\"}\n", string(res))
+	assert.Nil(t, m.scale(map[string]string{}, 1))
+	defer m.stop()
+	res, err = m.serve(context.Background(), []byte(`{"value": {}}`), 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"model": "fake"}`, string(res))
+
+	logs, _ := ioutil.ReadFile(log.Name())
+	assert.Equal(t, "cold start\n"+OutputGuard+OutputGuard+
+		"serving {\"value\": {}}\nwarning: this is a fake\n"+OutputGuard+OutputGuard, string(logs))
+}
@@ -0,0 +1,211 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// MetricsBuckets are the upper bounds, in seconds, of the buckets of the latency histograms
+var MetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// histogram counts the observed durations in MetricsBuckets
+type histogram struct {
+	// counts are the observations in each bucket, not cumulative
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// observe counts a duration
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(MetricsBuckets))
+	}
+	seconds := d.Seconds()
+	for i, bound := range MetricsBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// copy returns an independent copy of the histogram
+func (h *histogram) copy() histogram {
+	return histogram{append([]uint64(nil), h.counts...), h.count, h.sum}
+}
+
+// modelStats counts the requests served by a model and their latency,
+// and how the model was loaded and offloaded
+type modelStats struct {
+	mutex     sync.Mutex
+	served    uint64
+	total     time.Duration
+	last      time.Duration
+	lastError string
+	oomKills  uint64
+	// warm and cold count the activations served by the replicas and by cold runs
+	warm     uint64
+	cold     uint64
+	loads    uint64
+	offloads uint64
+	crashes  uint64
+	timeouts uint64
+	// loadTime, inference and runs are the histograms of the durations of the loads,
+	// of the answers of the processes and of the whole /run requests
+	loadTime  histogram
+	inference histogram
+	runs      histogram
+	// phases are the histograms of the phases reported by the processes, by name
+	phases map[string]*histogram
+}
+
+// record counts the activations served together by a replica in the given time,
+// or the error serving them
+func (s *modelStats) record(activations int, latency time.Duration, err error) {
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.warm += uint64(activations)
+	s.served += uint64(activations)
+	s.total += latency * time.Duration(activations)
+	s.last = latency
+	s.inference.observe(latency)
+}
+
+// recordCold counts an activation served by a cold run in the given time,
+// or the error serving it
+func (s *modelStats) recordCold(latency time.Duration, err error) {
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cold++
+	s.served++
+	s.total += latency
+	s.last = latency
+	s.inference.observe(latency)
+}
+
+// loaded counts a load of the model, which took the given time
+func (s *modelStats) loaded(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.loads++
+	s.loadTime.observe(d)
+}
+
+// offloaded counts an offload of the model
+func (s *modelStats) offloaded() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.offloads++
+}
+
+// crashed counts a process of the model terminated on its own
+func (s *modelStats) crashed() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.crashes++
+}
+
+// ran counts a /run request to the model, which took the given time
+func (s *modelStats) ran(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.runs.observe(d)
+}
+
+// phase records a phase reported by a process of the model, which took the given time
+func (s *modelStats) phase(name string, d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.phases == nil {
+		s.phases = map[string]*histogram{}
+	}
+	h, ok := s.phases[name]
+	if !ok {
+		h = &histogram{}
+		s.phases[name] = h
+	}
+	h.observe(d)
+}
+
+// oomKilled records a process of the model was killed for exceeding its memory limit
+func (s *modelStats) oomKilled(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.oomKills++
+	s.lastError = err.Error()
+}
+
+// fail records the last error of the model, counting the timeouts
+func (s *modelStats) fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastError = err.Error()
+	if errors.Is(err, ErrTimeout) {
+		s.timeouts++
+	}
+}
+
+// snapshot copies the stats, to read them without holding the mutex
+func (s *modelStats) snapshot() *modelStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	phases := map[string]*histogram{}
+	for name, h := range s.phases {
+		c := h.copy()
+		phases[name] = &c
+	}
+	return &modelStats{
+		served:    s.served,
+		total:     s.total,
+		last:      s.last,
+		lastError: s.lastError,
+		oomKills:  s.oomKills,
+		warm:      s.warm,
+		cold:      s.cold,
+		loads:     s.loads,
+		offloads:  s.offloads,
+		crashes:   s.crashes,
+		timeouts:  s.timeouts,
+		loadTime:  s.loadTime.copy(),
+		inference: s.inference.copy(),
+		runs:      s.runs.copy(),
+		phases:    phases,
+	}
+}
+
+// average is the mean latency of the requests served, 0 if none
+func (s *modelStats) average() time.Duration {
+	if s.served == 0 {
+		return 0
+	}
+	return s.total / time.Duration(s.served)
+}
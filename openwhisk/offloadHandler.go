@@ -22,7 +22,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strings"
 )
 
 func (ap *ActionProxy) offloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -42,116 +41,25 @@ func (ap *ActionProxy) offloadHandler(w http.ResponseWriter, r *http.Request) {
 		sendError(w, http.StatusBadRequest, fmt.Sprintf("Error reading request body: %v", err))
 		return
 	}
-	actionName := req.ActionName
-	if strings.Contains(actionName, "ptest04") {
-		// check if you have an action
-		if ap.theresnet18Executor == nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet"))
-			return
-		}
-		if ap.theresnet18Executor.started == false {
-			return
-		}
-		if ap.theresnet18Executor.started == true {
-			Debug("received a offload signal, now stopping resnet18")
-			ap.theresnet18Executor.Stop()
-		}
+	m := ap.models.match(req.ActionName)
+	if m == nil {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Not defined this model!"))
+		return
+	}
 
-	} else if strings.Contains(actionName, "ptest05") {
-		// check if you have an action
-		if ap.theresnet50Executor == nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet"))
-			return
-		}
-		if ap.theresnet50Executor.started == false {
-			return
-		}
-		if ap.theresnet50Executor.started == true {
-			Debug("received a offload signal, now stopping resnet50")
-			ap.theresnet50Executor.Stop()
-		}
-	} else if strings.Contains(actionName, "ptest06") {
-		// check if you have an action
-		if ap.theresnet152Executor == nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet"))
-			return
-		}
-		if ap.theresnet152Executor.started == false {
-			return
-		}
-		if ap.theresnet152Executor.started == true {
-			Debug("received a offload signal, now stopping resnet152")
-			ap.theresnet152Executor.Stop()
-		}
-	} else if strings.Contains(actionName, "ptest01") {
-		// check if you have an action
-		if ap.thealexExecutor == nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet"))
-			return
-		}
+	ap.offloadModel(m)
+}
 
-		if ap.thealexExecutor.started == false {
-			Debug("received a offload signal, alex has not started")
-			return
-		}
-		if ap.thealexExecutor.started == true {
-			Debug("received a offload signal, now stopping alex")
-			ap.thealexExecutor.Stop()
-		}
-	} else if strings.Contains(actionName, "ptest02") {
-		// check if you have an action
-		if ap.thevggExecutor == nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet"))
-			return
-		}
-		if ap.thevggExecutor.started == false {
-			return
-		}
-		if ap.thevggExecutor.started == true {
-			Debug("received a offload signal, now stopping vgg")
-			ap.thevggExecutor.Stop()
-		}
-	} else if strings.Contains(actionName, "ptest03") {
-		// check if you have an action
-		if ap.theinceptionExecutor == nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet"))
-			return
-		}
-		if ap.theinceptionExecutor.started == false {
-			return
-		}
-		if ap.theinceptionExecutor.started == true {
-			Debug("received a offload signal, now stopping inception")
-			ap.theinceptionExecutor.Stop()
-		}
-	} else if strings.Contains(actionName, "ptest07") {
-		// check if you have an action
-		if ap.thegooglenetExecutor == nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet"))
-			return
-		}
-		if ap.thegooglenetExecutor.started == false {
-			return
-		}
-		if ap.thegooglenetExecutor.started == true {
-			Debug("received a offload signal, now stopping googlenet")
-			ap.thegooglenetExecutor.Stop()
-		}
-	} else if strings.Contains(actionName, "ptest08") {
-		// check if you have an action
-		if ap.thebertExecutor == nil {
-			sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet"))
-			return
-		}
-		if ap.thebertExecutor.started == false {
-			return
-		}
-		if ap.thebertExecutor.started == true {
-			Debug("received a offload signal, now stopping bert")
-			ap.thebertExecutor.Stop()
-		}
-	} else {
-		sendError(w, http.StatusBadRequest, fmt.Sprintf("Not defined this model!"))
+// offloadModel cancels the loads of the model in progress and stops all its replicas
+func (ap *ActionProxy) offloadModel(m *Model) {
+	// interrupt the loads, then wait for the runs in progress on the model
+	m.cancelLoad()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.replicas) == 0 {
+		modelLog.With("model", m.Name).Debugf("not started, nothing to offload")
 		return
 	}
+	modelLog.With("model", m.Name).Infof("offloading")
+	m.scale(ap.env, 0)
 }
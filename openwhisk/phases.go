@@ -0,0 +1,96 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// PhaseMarker starts the lines a model writes on file descriptor 3, before its answer,
+// to report a phase it went through, like importing its libraries or loading its weights:
+//
+//	XXX_OW_PHASE_XXX {"phase": "import", "start": 1714816890.120, "end": 1714816891.530}
+//
+// The times are in seconds since the epoch. With protocol version 2 the phases
+// are reported in messages with id 0, carrying the same JSON object.
+const PhaseMarker = "XXX_OW_PHASE_XXX"
+
+// executorPhase is a phase reported by a model
+type executorPhase struct {
+	Name  string  `json:"phase"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// parsePhase decodes the report of a phase
+func parsePhase(payload []byte) (executorPhase, error) {
+	var phase executorPhase
+	if err := json.Unmarshal(payload, &phase); err != nil {
+		return phase, err
+	}
+	if phase.Name == "" {
+		return phase, fmt.Errorf("phase without a name")
+	}
+	if phase.End < phase.Start {
+		return phase, fmt.Errorf("phase %s ending before starting", phase.Name)
+	}
+	return phase, nil
+}
+
+// duration is how long the phase took
+func (phase executorPhase) duration() time.Duration {
+	return time.Duration((phase.End - phase.Start) * float64(time.Second))
+}
+
+// isPhase checks if the line reports a phase
+func isPhase(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(PhaseMarker))
+}
+
+// reportPhase records the phase reported by the process in the timing of the request,
+// if any, and in the stats of the model
+func (proc *modelExecutor) reportPhase(timing *runTiming, payload []byte) {
+	phase, err := parsePhase(payload)
+	if err != nil {
+		executorLog.Warnf("%s reported an invalid phase: %v", proc.name, err)
+		return
+	}
+	timing.phase(phase.Name, phase.duration())
+	if proc.onPhase != nil {
+		proc.onPhase(phase.Name, phase.duration())
+	}
+}
+
+// readAnswer reads a line of answer on file descriptor 3, recording the phases reported before it.
+// The first byte of the answer is marked in the timing, if any, as soon as it arrives.
+func (proc *modelExecutor) readAnswer(timing *runTiming) ([]byte, error) {
+	for {
+		if next, err := proc.output.Peek(1); err == nil && next[0] != PhaseMarker[0] {
+			timing.mark(PhaseFirstByte)
+		}
+		line, err := proc.output.ReadBytes('\n')
+		if err == nil && isPhase(line) {
+			proc.reportPhase(timing, line[len(PhaseMarker):])
+			continue
+		}
+		return line, err
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePhase(t *testing.T) {
+	phase, err := parsePhase([]byte(`{"phase": "load", "start": 10.5, "end": 12}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "load", phase.Name)
+	assert.Equal(t, 1500*time.Millisecond, phase.duration())
+	_, err = parsePhase([]byte(`{"start": 1, "end": 2}`))
+	assert.NotNil(t, err)
+	_, err = parsePhase([]byte(`{"phase": "load", "start": 2, "end": 1}`))
+	assert.NotNil(t, err)
+}
+
+func TestReadReply_phases(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrame(&buf, 0, []byte(`{"phase": "infer", "start": 1, "end": 2}`))
+	writeFrame(&buf, 1, []byte(`{"ok": true}`))
+	phases := []string{}
+	reply, err := readReply(bufio.NewReader(&buf), 1, "test", func(payload []byte) {
+		phases = append(phases, string(payload))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"ok": true}`, string(reply))
+	assert.Equal(t, []string{`{"phase": "infer", "start": 1, "end": 2}`}, phases)
+}
+
+// postPhases posts a /run request and returns the phases reported, in milliseconds
+func postPhases(t *testing.T, ts *httptest.Server, body string) ([]string, map[string]float64) {
+	res, err := http.Post(ts.URL+"/run", "application/json", strings.NewReader(body))
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	names := []string{}
+	phases := map[string]float64{}
+	for _, field := range strings.Split(res.Header.Get(PhasesHeader), ";") {
+		if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+			ms, err := strconv.ParseFloat(kv[1], 64)
+			assert.Nil(t, err)
+			names = append(names, kv[0])
+			phases[kv[0]] = ms
+		}
+	}
+	return names, phases
+}
+
+func TestPhases(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "phased", Action: "/guest/phased", Load: "_test/phases.sh", Cold: "_test/cold_phases.sh", Ack: true},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	// a cold run reports all the phases
+	names, phases := postPhases(t, ts, `{"action_name":"/guest/phased","value":{}}`)
+	assert.Equal(t, []string{"import", "load", "infer"}, names)
+	assert.True(t, phases["import"] >= 50)
+	assert.True(t, phases["load"] >= 100)
+	assert.True(t, phases["infer"] >= 20)
+
+	// a warm run only the inference, as the others were reported loading
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/phased"}`)
+	assert.Equal(t, http.StatusOK, status)
+	names, _ = postPhases(t, ts, `{"action_name":"/guest/phased","value":{}}`)
+	assert.Equal(t, []string{"infer"}, names)
+
+	// the phases are aggregated in the metrics
+	samples := getMetrics(t, ts)
+	assert.Equal(t, "2", samples[`openwhisk_phase_duration_seconds_count{model="phased",phase="import"}`])
+	assert.Equal(t, "2", samples[`openwhisk_phase_duration_seconds_count{model="phased",phase="load"}`])
+	assert.Equal(t, "2", samples[`openwhisk_phase_duration_seconds_count{model="phased",phase="infer"}`])
+}
@@ -0,0 +1,109 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procRoot is where the proc filesystem is mounted
+var procRoot = "/proc"
+
+// procStat holds the fields of /proc/<pid>/stat used by the proxy
+type procStat struct {
+	pid  int
+	ppid int
+	pgrp int
+	rss  uint64
+	// state is R for running, S for sleeping, Z for zombie and the like
+	state string
+}
+
+// readProcStat parses /proc/<pid>/stat
+func readProcStat(pid int) (*procStat, error) {
+	buf, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/stat", procRoot, pid))
+	if err != nil {
+		return nil, err
+	}
+	// the command name is in parenthesis and can contain spaces
+	line := string(buf)
+	end := strings.LastIndex(line, ")")
+	if end < 0 {
+		return nil, fmt.Errorf("cannot parse stat of %d", pid)
+	}
+	// fields after the name start with the state (field 3)
+	fields := strings.Fields(line[end+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("cannot parse stat of %d", pid)
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	pgrp, _ := strconv.Atoi(fields[2])
+	pages, _ := strconv.ParseUint(fields[21], 10, 64)
+	return &procStat{pid, ppid, pgrp, pages * uint64(os.Getpagesize()), fields[0]}, nil
+}
+
+// listProcs returns the stat of every process visible in /proc
+func listProcs() []*procStat {
+	res := []*procStat{}
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return res
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := readProcStat(pid)
+		if err == nil {
+			res = append(res, stat)
+		}
+	}
+	return res
+}
+
+// threads returns the ids of the threads of the process,
+// only the process itself if they cannot be listed
+func threads(pid int) []int {
+	entries, err := ioutil.ReadDir(fmt.Sprintf("%s/%d/task", procRoot, pid))
+	if err != nil {
+		return []int{pid}
+	}
+	res := []int{}
+	for _, entry := range entries {
+		if tid, err := strconv.Atoi(entry.Name()); err == nil {
+			res = append(res, tid)
+		}
+	}
+	return res
+}
+
+// groupRSS returns the resident memory in bytes of all the processes in the process group
+func groupRSS(pgid int) uint64 {
+	var total uint64
+	for _, stat := range listProcs() {
+		if stat.pgrp == pgid {
+			total += stat.rss
+		}
+	}
+	return total
+}
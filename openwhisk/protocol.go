@@ -0,0 +1,117 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Versions of the action loop protocol.
+// In version 1 each request and each answer is a single line.
+// In version 2 each message is framed: a header line with the request id
+// and the length of the payload, then the payload and a newline.
+// The answer to a request carries the same id.
+const (
+	ProtocolV1 = 1
+	ProtocolV2 = 2
+)
+
+// ProtocolEnv is the variable telling the action the protocol version requested by the proxy,
+// the action confirms the version it speaks in the acknowledgement
+const ProtocolEnv = "__OW_PROTOCOL"
+
+// requestedProtocol is the protocol version requested in the environment of an action
+func requestedProtocol(env map[string]string) int {
+	version, err := strconv.Atoi(env[ProtocolEnv])
+	if err != nil || version < ProtocolV1 {
+		return ProtocolV1
+	}
+	if version > ProtocolV2 {
+		return ProtocolV2
+	}
+	return version
+}
+
+// negotiatedProtocol is the version both the proxy and the action speak
+func negotiatedProtocol(requested int, ack ActionAck) int {
+	if ack.Protocol < requested {
+		if ack.Protocol < ProtocolV1 {
+			return ProtocolV1
+		}
+		return ack.Protocol
+	}
+	return requested
+}
+
+// writeFrame writes a message of protocol version 2
+func writeFrame(w io.Writer, id uint64, payload []byte) error {
+	_, err := fmt.Fprintf(w, "%d %d\n%s\n", id, len(payload), payload)
+	return err
+}
+
+// readFrame reads a message of protocol version 2
+func readFrame(r *bufio.Reader) (uint64, []byte, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return 0, nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 2 {
+		return 0, nil, fmt.Errorf("stray line from the action: %q", header)
+	}
+	id, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("stray line from the action: %q", header)
+	}
+	length, err := strconv.Atoi(fields[1])
+	if err != nil || length < 0 {
+		return 0, nil, fmt.Errorf("stray line from the action: %q", header)
+	}
+	payload := make([]byte, length+1)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if payload[length] != '\n' {
+		return 0, nil, fmt.Errorf("message %d longer than %d bytes", id, length)
+	}
+	return id, payload[:length], nil
+}
+
+// readReply reads the messages of protocol version 2 until the answer to the request with the given id,
+// passing the phases reported in messages with id 0 to phase, if not nil,
+// and reporting and discarding the answers to other requests
+func readReply(r *bufio.Reader, id uint64, name string, phase func(payload []byte)) ([]byte, error) {
+	for {
+		replyID, payload, err := readFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if replyID == id {
+			return payload, nil
+		}
+		if replyID == 0 && phase != nil {
+			phase(payload)
+			continue
+		}
+		executorLog.Debugf("%s: discarding the answer to request %d while waiting for %d", name, replyID, id)
+	}
+}
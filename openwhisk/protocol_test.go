@@ -0,0 +1,154 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrame_roundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, writeFrame(&buf, 1, []byte("one")))
+	assert.Nil(t, writeFrame(&buf, 2, []byte("two\nlines")))
+	assert.Equal(t, "1 3\none\n2 9\ntwo\nlines\n", buf.String())
+
+	// the answers to other requests are discarded
+	reply, err := readReply(bufio.NewReader(&buf), 2, "test", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "two\nlines", string(reply))
+}
+
+func TestFrame_stray(t *testing.T) {
+	_, _, err := readFrame(bufio.NewReader(strings.NewReader("hello world!\n")))
+	assert.Contains(t, err.Error(), "stray line")
+	_, _, err = readFrame(bufio.NewReader(strings.NewReader("1 2\nlonger\n")))
+	assert.Contains(t, err.Error(), "longer than 2 bytes")
+}
+
+func TestProtocol_negotiation(t *testing.T) {
+	assert.Equal(t, ProtocolV1, requestedProtocol(map[string]string{}))
+	assert.Equal(t, ProtocolV2, requestedProtocol(map[string]string{ProtocolEnv: "2"}))
+	assert.Equal(t, ProtocolV1, negotiatedProtocol(ProtocolV2, ActionAck{Ok: true}))
+	assert.Equal(t, ProtocolV2, negotiatedProtocol(ProtocolV2, ActionAck{Ok: true, Protocol: 2}))
+	assert.Equal(t, ProtocolV1, negotiatedProtocol(ProtocolV1, ActionAck{Ok: true, Protocol: 2}))
+}
+
+func TestModelExecutor_protocolV2(t *testing.T) {
+	for _, protocol := range []int{0, ProtocolV2} {
+		m := newModel(ModelSpec{Name: "framed", Load: "_test/framed.sh", Protocol: protocol})
+		assert.Nil(t, m.scale(map[string]string{ProtocolEnv: "2"}, 1))
+		res, err := m.serve(context.Background(), []byte(`{"value": {}}`), 0, 1)
+		assert.Nil(t, err)
+		if protocol == ProtocolV2 {
+			assert.Equal(t, `{"model": "framed"}`, string(res))
+		} else {
+			// the protocol requested to the action does not apply to the models
+			assert.Equal(t, `{"model": "fake"}`, string(res))
+		}
+		m.stop()
+	}
+}
+
+func TestModelExecutor_protocolFallback(t *testing.T) {
+	timeout := DefaultTimeoutAck
+	DefaultTimeoutAck = 100 * time.Millisecond
+	defer func() { DefaultTimeoutAck = timeout }()
+
+	// a model not acknowledging the version is spoken to with version 1
+	m := newModel(ModelSpec{Name: "fake", Load: "_test/model.sh", Protocol: ProtocolV2})
+	start := time.Now()
+	assert.Nil(t, m.scale(map[string]string{}, 1))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, ProtocolV1, m.replicas[0].executor.(*modelExecutor).protocol)
+	res, err := m.serve(context.Background(), []byte(`{"value": {}}`), 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"model": "fake"}`, strings.TrimSpace(string(res)))
+	m.stop()
+
+	// unless the acknowledgement is required
+	m = newModel(ModelSpec{Name: "fake", Load: "_test/model.sh", Protocol: ProtocolV2, Ack: true, LoadTimeoutMS: 300})
+	assert.NotNil(t, m.scale(map[string]string{}, 1))
+	m.stop()
+}
+
+func TestModelExecutor_longAnswer(t *testing.T) {
+	m := newModel(ModelSpec{Name: "long", Load: "_test/long.sh"})
+	assert.Nil(t, m.scale(map[string]string{}, 1))
+	defer m.stop()
+	// the answers longer than the buffer of the reader do not leak into the next ones
+	for i := 0; i < 2; i++ {
+		res, err := m.serve(context.Background(), []byte(`{"value": {}}`), 0, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 6000+len(`{"long": ""}`), len(res))
+		assert.True(t, strings.HasSuffix(string(res), `xxx"}`))
+	}
+}
+
+func ExampleExecutor_protocolV2() {
+	log, _ := ioutil.TempFile("", "log")
+	proc := NewExecutor(log, log, "_test/framed.sh", map[string]string{ProtocolEnv: "2"})
+	err := proc.Start(false)
+	fmt.Println(err, proc.protocol)
+	res, _ := proc.Interact([]byte(`{"value": {}}`))
+	fmt.Printf("%s\n", res)
+	proc.Stop()
+	dump(log)
+	// Output:
+	// <nil> 2
+	// {"model": "framed"}
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func TestExecutor_protocolFallback(t *testing.T) {
+	timeout := DefaultTimeoutAck
+	DefaultTimeoutAck = 100 * time.Millisecond
+	defer func() { DefaultTimeoutAck = timeout }()
+	log, _ := ioutil.TempFile("", "log")
+	defer os.Remove(log.Name())
+
+	// an action not acknowledging the version is spoken to with version 1
+	proc := NewExecutor(log, log, "_test/model.sh", map[string]string{ProtocolEnv: "2"})
+	start := time.Now()
+	assert.Nil(t, proc.Start(false))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, ProtocolV1, proc.protocol)
+	res, err := proc.Interact([]byte(`{"value": {}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"model": "fake"}`, strings.TrimSpace(string(res)))
+	proc.Stop()
+
+	// while one acknowledging it speaks version 2
+	proc = NewExecutor(log, log, "_test/framed.sh", map[string]string{ProtocolEnv: "2"})
+	assert.Nil(t, proc.Start(false))
+	assert.Equal(t, ProtocolV2, proc.protocol)
+	res, err = proc.Interact([]byte(`{"value": {}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"model": "framed"}`, string(res))
+	proc.Stop()
+}
@@ -0,0 +1,185 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordedPaths are the requests recorded
+var recordedPaths = map[string]bool{"/init": true, "/load": true, "/offload": true, "/run": true, "/clean": true}
+
+// RecordedCall is a request to the proxy recorded in a JSONL file, to be replayed
+type RecordedCall struct {
+	Time time.Time `json:"time"`
+	Path string    `json:"path"`
+	// Body is the body of the request, as JSON, or as a string if it is not JSON
+	Body      json.RawMessage `json:"body,omitempty"`
+	Status    int             `json:"status"`
+	LatencyMS float64         `json:"latency_ms"`
+}
+
+// recorder writes the requests served in a JSONL file, one line each
+type recorder struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+// Record writes the /init, /load, /offload, /run and /clean requests
+// served by the proxy to out, as JSON lines, to be replayed
+func (ap *ActionProxy) Record(out io.Writer) {
+	ap.recorder = &recorder{out: out}
+}
+
+// record serves the request with the handler and records it, if recorded
+func (rec *recorder) record(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request)) {
+	if rec == nil || !recordedPaths[r.URL.Path] {
+		handler(w, r)
+		return
+	}
+	start := time.Now()
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	handler(sw, r)
+	call := RecordedCall{
+		Time:      start.UTC(),
+		Path:      r.URL.Path,
+		Body:      recordedBody(body),
+		Status:    sw.status,
+		LatencyMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	line, err := json.Marshal(call)
+	if err != nil {
+		httpLog.Warnf("cannot record %s: %v", r.URL.Path, err)
+		return
+	}
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if _, err := rec.out.Write(append(line, '\n')); err != nil {
+		httpLog.Warnf("cannot record %s: %v", r.URL.Path, err)
+	}
+}
+
+// redactedFields are the fields of the bodies which can carry credentials
+var redactedFields = map[string]bool{"api_key": true, "auth": true, "authorization": true}
+
+// redacted replaces the secrets in the recorded bodies
+var redacted = json.RawMessage(`"redacted"`)
+
+// recordedBody is the body as JSON if it is, with its secrets redacted, else as a JSON string
+func recordedBody(body []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		var buf bytes.Buffer
+		if json.Compact(&buf, body) == nil {
+			return redact(buf.Bytes())
+		}
+	}
+	s, _ := json.Marshal(string(body))
+	return s
+}
+
+// redact replaces the credentials of the body, at its top level and in its value,
+// and the values of the environment of an /init, as they can be secrets
+func redact(body []byte) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return body
+	}
+	changed := redactFields(fields)
+	var value map[string]json.RawMessage
+	if json.Unmarshal(fields["value"], &value) == nil {
+		valueChanged := redactFields(value)
+		var env map[string]json.RawMessage
+		if json.Unmarshal(value["env"], &env) == nil && len(env) > 0 {
+			for name := range env {
+				env[name] = redacted
+			}
+			value["env"], _ = json.Marshal(env)
+			valueChanged = true
+		}
+		if valueChanged {
+			fields["value"], _ = json.Marshal(value)
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	res, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return res
+}
+
+// redactFields replaces the values of the credentials among the fields,
+// returning if any was
+func redactFields(fields map[string]json.RawMessage) bool {
+	changed := false
+	for name := range fields {
+		if redactedFields[strings.ToLower(name)] {
+			fields[name] = redacted
+			changed = true
+		}
+	}
+	return changed
+}
+
+// requestBody is the body to send replaying the call
+func (call *RecordedCall) requestBody() []byte {
+	var s string
+	if json.Unmarshal(call.Body, &s) == nil {
+		return []byte(s)
+	}
+	return call.Body
+}
+
+// statusWriter keeps the status of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader keeps the status before writing it
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush sends the response written so far
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
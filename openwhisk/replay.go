@@ -0,0 +1,214 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ReadRecordedCalls reads the calls recorded in JSONL, in the order they were received
+func ReadRecordedCalls(in io.Reader) ([]RecordedCall, error) {
+	calls := []RecordedCall{}
+	scanner := bufio.NewScanner(in)
+	// the bodies of /init can carry large actions
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var call RecordedCall
+		if err := json.Unmarshal(line, &call); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		calls = append(calls, call)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(calls, func(i, j int) bool { return calls[i].Time.Before(calls[j].Time) })
+	return calls, nil
+}
+
+// ReplayStats are the outcomes of the /run requests to a model replayed
+type ReplayStats struct {
+	Runs    int
+	Warm    int
+	Cold    int
+	Generic int
+	Errors  int
+	// Latencies are the ones of the requests answered successfully
+	Latencies []time.Duration
+}
+
+// ReplayReport describes a replay: the calls sent and the failed ones,
+// and the /run requests by model, "" for the action
+type ReplayReport struct {
+	Calls  int
+	Errors int
+	Models map[string]*ReplayStats
+}
+
+// Replay sends the calls to the proxy at url, honoring their inter-arrival times
+// divided by speed, and describes how they were served. If speed is 0 the calls are sent
+// as fast as possible, one after the other in the recorded order, so that each one
+// is sent after the previous ones completed, as an /init before the following /run.
+func Replay(calls []RecordedCall, url string, speed float64) *ReplayReport {
+	report := &ReplayReport{Models: map[string]*ReplayStats{}}
+	if len(calls) == 0 {
+		return report
+	}
+	if speed == 0 {
+		for i := range calls {
+			path, latency, err := replayCall(url, &calls[i])
+			report.add(&calls[i], path, latency, err)
+		}
+		return report
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	first := calls[0].Time
+	start := time.Now()
+	for i := range calls {
+		call := &calls[i]
+		at := time.Duration(float64(call.Time.Sub(first)) / speed)
+		time.Sleep(time.Until(start.Add(at)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path, latency, err := replayCall(url, call)
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.add(call, path, latency, err)
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// replayCall sends the call and returns the path taken by a /run request and its latency
+func replayCall(url string, call *RecordedCall) (string, time.Duration, error) {
+	start := time.Now()
+	res, err := http.Post(strings.TrimRight(url, "/")+call.Path, "application/json", bytes.NewReader(call.requestBody()))
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+	latency := time.Since(start)
+	if res.StatusCode >= 400 {
+		return "", latency, fmt.Errorf("%s answered %d", call.Path, res.StatusCode)
+	}
+	return timingPath(res.Header.Get(TimingHeader)), latency, nil
+}
+
+// timingPath is the path in the timing header
+func timingPath(timing string) string {
+	for _, field := range strings.Split(timing, ";") {
+		if strings.HasPrefix(field, "path=") {
+			return strings.TrimPrefix(field, "path=")
+		}
+	}
+	return ""
+}
+
+// add counts the outcome of a call
+func (report *ReplayReport) add(call *RecordedCall, path string, latency time.Duration, err error) {
+	report.Calls++
+	if err != nil {
+		report.Errors++
+	}
+	if call.Path != "/run" {
+		return
+	}
+	var req requestBody
+	json.Unmarshal(call.requestBody(), &req)
+	stats, ok := report.Models[req.ActionName]
+	if !ok {
+		stats = &ReplayStats{}
+		report.Models[req.ActionName] = stats
+	}
+	stats.Runs++
+	if err != nil {
+		stats.Errors++
+		return
+	}
+	switch path {
+	case PathWarm:
+		stats.Warm++
+	case PathCold:
+		stats.Cold++
+	case PathGeneric:
+		stats.Generic++
+	}
+	stats.Latencies = append(stats.Latencies, latency)
+}
+
+// Percentile is the latency below which the given percentage of the requests were answered,
+// 0 if none was
+func (stats *ReplayStats) Percentile(p float64) time.Duration {
+	if len(stats.Latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, stats.Latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Print writes the report as a table, a row for each model
+func (report *ReplayReport) Print(out io.Writer) {
+	fmt.Fprintf(out, "%d calls replayed, %d failed\n", report.Calls, report.Errors)
+	names := []string{}
+	for name := range report.Models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "model\truns\twarm\tcold\tgeneric\terrors\tp50 ms\tp90 ms\tp99 ms")
+	for _, name := range names {
+		stats := report.Models[name]
+		if name == "" {
+			name = "(action)"
+		}
+		rate := func(n int) string {
+			return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(stats.Runs))
+		}
+		ms := func(p float64) string {
+			return fmt.Sprintf("%.1f", float64(stats.Percentile(p))/float64(time.Millisecond))
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", name, stats.Runs,
+			rate(stats.Warm), rate(stats.Cold), rate(stats.Generic), stats.Errors, ms(50), ms(90), ms(99))
+	}
+	w.Flush()
+}
@@ -0,0 +1,146 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeModelProxy serves a fake model, preloadable and with cold runs
+func fakeModelProxy() (*ActionProxy, *httptest.Server) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "fake", Action: "/guest/fake", Load: "_test/model.sh", Cold: "_test/cold.sh"},
+	})
+	ap.models.prepare(ap.env)
+	return ap, httptest.NewServer(ap)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ap, ts := fakeModelProxy()
+	var recorded bytes.Buffer
+	ap.Record(&recorded)
+	doPost(ts.URL+"/run", `{"action_name":"/guest/fake","value":{}}`)
+	doPost(ts.URL+"/load", `{"action_name":"/guest/fake"}`)
+	doPost(ts.URL+"/run", `{"action_name":"/guest/fake","value":{}}`)
+	doPost(ts.URL+"/run", `not json`)
+	// not recorded
+	http.Get(ts.URL + "/status")
+	ts.Close()
+	ap.StopAllExecutorsExcept("")
+
+	calls, err := ReadRecordedCalls(&recorded)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(calls))
+	assert.Equal(t, "/run", calls[0].Path)
+	assert.Equal(t, `{"action_name":"/guest/fake","value":{}}`, string(calls[0].Body))
+	assert.Equal(t, http.StatusOK, calls[0].Status)
+	assert.True(t, calls[0].LatencyMS > 0)
+	assert.Equal(t, "/load", calls[1].Path)
+	assert.Equal(t, `"not json"`, string(calls[3].Body))
+	assert.Equal(t, http.StatusBadRequest, calls[3].Status)
+
+	// the replay against a fresh proxy takes the same paths
+	ap, ts = fakeModelProxy()
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+	report := Replay(calls, ts.URL, 0)
+	assert.Equal(t, 4, report.Calls)
+	assert.Equal(t, 1, report.Errors)
+	stats := report.Models["/guest/fake"]
+	assert.Equal(t, 2, stats.Runs)
+	assert.Equal(t, 1, stats.Cold)
+	assert.Equal(t, 1, stats.Warm)
+	assert.Equal(t, 2, len(stats.Latencies))
+	assert.Equal(t, 1, report.Models[""].Errors)
+
+	var out bytes.Buffer
+	report.Print(&out)
+	assert.Contains(t, out.String(), "4 calls replayed, 1 failed")
+	lines := strings.Split(out.String(), "\n")
+	assert.True(t, strings.HasPrefix(lines[1], "model "))
+	assert.Contains(t, lines[2], "(action)")
+	assert.Contains(t, lines[3], "/guest/fake")
+	assert.Contains(t, lines[3], "50.0%")
+
+	// the inter-arrival times are honored
+	start := time.Now()
+	report = Replay(calls[2:], ts.URL, 1)
+	assert.True(t, time.Since(start) >= calls[3].Time.Sub(calls[2].Time))
+	assert.Equal(t, 1, report.Models["/guest/fake"].Warm)
+}
+
+func TestRecord_redacted(t *testing.T) {
+	assert.Equal(t, `{"api_key":"redacted","value":{"code":"main","env":{"TOKEN":"redacted"}}}`,
+		string(recordedBody([]byte(`{"api_key": "k", "value": {"code": "main", "env": {"TOKEN": "secret"}}}`))))
+	assert.Equal(t, `{"action_name":"/guest/fake","value":{"Authorization":"redacted","x":1}}`,
+		string(recordedBody([]byte(`{"action_name":"/guest/fake","value":{"Authorization":"Basic a","x":1}}`))))
+	// untouched without secrets
+	assert.Equal(t, `{"value":{"b":1,"a":2}}`, string(recordedBody([]byte(`{"value": {"b": 1, "a": 2}}`))))
+}
+
+func TestReplay_ordered(t *testing.T) {
+	var mutex sync.Mutex
+	served := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/init" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		mutex.Lock()
+		served = append(served, r.URL.Path)
+		mutex.Unlock()
+	}))
+	defer ts.Close()
+	now := time.Now()
+	calls := []RecordedCall{
+		{Time: now, Path: "/init", Body: []byte(`{"value":{}}`)},
+		{Time: now.Add(time.Millisecond), Path: "/run", Body: []byte(`{"action_name":"/guest/fake","value":{}}`)},
+		{Time: now.Add(2 * time.Millisecond), Path: "/clean"},
+		{Time: now.Add(3 * time.Millisecond), Path: "/run", Body: []byte(`{"action_name":"/guest/fake","value":{}}`)},
+	}
+
+	// as fast as possible, but each call once the previous one was answered
+	report := Replay(calls, ts.URL, 0)
+	assert.Equal(t, 0, report.Errors)
+	assert.Equal(t, []string{"/init", "/run", "/clean", "/run"}, served)
+}
+
+func TestReplayStats_percentile(t *testing.T) {
+	stats := &ReplayStats{}
+	assert.Equal(t, time.Duration(0), stats.Percentile(50))
+	for i := 10; i >= 1; i-- {
+		stats.Latencies = append(stats.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 5*time.Millisecond, stats.Percentile(50))
+	assert.Equal(t, 9*time.Millisecond, stats.Percentile(90))
+	assert.Equal(t, 10*time.Millisecond, stats.Percentile(99))
+	assert.Equal(t, 1*time.Millisecond, stats.Percentile(0))
+}
+
+func TestReadRecordedCalls_invalid(t *testing.T) {
+	_, err := ReadRecordedCalls(strings.NewReader("{\"path\":\"/run\"}\n\nnot json\n"))
+	assert.Contains(t, err.Error(), "line 3")
+}
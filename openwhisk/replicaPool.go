@@ -0,0 +1,256 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// errNotLoaded is returned serving a model with no replica ready
+var errNotLoaded = errors.New("model not loaded")
+
+// replica is one of the identical processes serving a preloaded model
+type replica struct {
+	// inflight is the number of requests the replica is serving, accessed atomically
+	inflight int32
+	// restarts counts the restarts since the replica last served a request, accessed atomically
+	restarts int32
+	// executor is replaced when the replica is restarted, holding the mutex of the model
+	executor ModelExecutor
+	// stopped is closed when the replica is removed from the pool
+	stopped chan struct{}
+	// since is when the process of the replica was loaded, updated with the executor
+	since time.Time
+}
+
+func newReplica(executor ModelExecutor) *replica {
+	return &replica{executor: executor, stopped: make(chan struct{}), since: time.Now()}
+}
+
+// stop removes the replica for good: its process is killed and not restarted
+func (rep *replica) stop() {
+	close(rep.stopped)
+	rep.executor.Stop()
+}
+
+// state is the lifecycle state of the process of the replica,
+// busy while it serves a request. The caller must hold the mutex.
+func (rep *replica) state() ExecutorState {
+	state := rep.executor.State()
+	if state == StateReady && atomic.LoadInt32(&rep.inflight) > 0 {
+		return StateBusy
+	}
+	return state
+}
+
+// replicaCount is the number of replicas ready to serve,
+// the caller must hold the mutex
+func (m *Model) replicaCount() int {
+	count := 0
+	for _, rep := range m.replicas {
+		if rep.executor.State() == StateReady {
+			count++
+		}
+	}
+	return count
+}
+
+// scale starts or stops replicas until n of them are ready to serve.
+// Replicas not ready anymore, crashed or being restarted, are discarded first.
+// The caller must hold the mutex for writing.
+func (m *Model) scale(env map[string]string, n int) error {
+	defer func() { m.queue.resize(len(m.replicas)) }()
+
+	m.trim(n)
+	started, err := m.startReplicas(env, n-len(m.replicas), nil)
+	m.add(env, started)
+	return err
+}
+
+// trim discards the replicas not ready anymore, and stops the ones exceeding n.
+// The caller must hold the mutex for writing.
+func (m *Model) trim(n int) {
+	if n == 0 && len(m.replicas) > 0 {
+		m.stats.offloaded()
+	}
+	ready := []*replica{}
+	for _, rep := range m.replicas {
+		if rep.executor.State() == StateReady {
+			ready = append(ready, rep)
+		} else {
+			rep.stop()
+		}
+	}
+	for len(ready) > n {
+		last := ready[len(ready)-1]
+		last.stop()
+		ready = ready[:len(ready)-1]
+	}
+	m.replicas = ready
+}
+
+// startReplicas starts count new replicas, stopping at the first failure,
+// and returns the ones started. Cancelling the load, if any, aborts the replica being started.
+// It does not need the mutex, as the replicas are not in the pool yet.
+func (m *Model) startReplicas(env map[string]string, count int, job *loadJob) ([]*replica, error) {
+	started := []*replica{}
+	for i := 0; i < count; i++ {
+		modelLog.Debugf("starting replica %d of %d of %s", i+1, count, m.Name)
+		var executor ModelExecutor
+		cell := m.stemCell()
+		if cell != nil {
+			executor = cell
+		} else if executor = m.newExecutor(env); executor == nil {
+			return started, fmt.Errorf("cannot create the %s executor", m.Name)
+		}
+		if job != nil && !job.track(executor) {
+			executor.Stop()
+			return started, errLoadCancelled
+		}
+		var err error
+		if cell != nil {
+			err = m.specialize(cell, env)
+		} else {
+			err = executor.Start(m.Ack, m.loadTimeout())
+		}
+		if job != nil {
+			job.untrack(executor)
+		}
+		if errors.Is(err, ErrAborted) {
+			err = errLoadCancelled
+		}
+		if err != nil {
+			executor.Stop()
+			return started, err
+		}
+		started = append(started, newReplica(executor))
+	}
+	return started, nil
+}
+
+// add puts the started replicas in the pool, under supervision.
+// The caller must hold the mutex for writing.
+func (m *Model) add(env map[string]string, started []*replica) {
+	for _, rep := range started {
+		m.replicas = append(m.replicas, rep)
+		go m.supervise(rep, env)
+	}
+}
+
+// pick returns the ready replica serving the fewest requests, or nil if none is ready,
+// and counts the request on it: the caller must call done when the request is served.
+// The caller must hold the mutex.
+func (m *Model) pick() *replica {
+	for {
+		var best *replica
+		var bestLoad int32
+		for _, rep := range m.replicas {
+			if rep.executor.State() != StateReady {
+				continue
+			}
+			load := atomic.LoadInt32(&rep.inflight)
+			if best == nil || load < bestLoad {
+				best, bestLoad = rep, load
+			}
+		}
+		// retry if another request picked the same replica meanwhile
+		if best == nil || atomic.CompareAndSwapInt32(&best.inflight, bestLoad, bestLoad+1) {
+			return best
+		}
+	}
+}
+
+// done records the replica finished serving a request
+func (rep *replica) done() {
+	atomic.AddInt32(&rep.inflight, -1)
+}
+
+// drop stops and removes a replica,
+// the caller must hold the mutex for writing
+func (m *Model) drop(failed *replica) {
+	for i, rep := range m.replicas {
+		if rep == failed {
+			rep.stop()
+			m.replicas = append(m.replicas[:i], m.replicas[i+1:]...)
+			m.queue.resize(len(m.replicas))
+			return
+		}
+	}
+}
+
+// serve sends a line to the least busy replica and returns its answer,
+// waiting up to the timeout or the one of the model if it is 0.
+// The line carries the given number of activations, whose logs are then terminated.
+// It fails with errNotLoaded if no replica is ready. If the replica fails
+// its process is stopped, and its supervisor restarts it.
+// The lines about the request are written with the logger of the context.
+func (m *Model) serve(ctx context.Context, line []byte, timeout time.Duration, activations int) ([]byte, error) {
+	log := requestLog(ctx).Subsystem("executor").With("model", m.Name)
+	m.mutex.RLock()
+	rep := m.pick()
+	if rep == nil {
+		m.mutex.RUnlock()
+		return nil, errNotLoaded
+	}
+	m.touch()
+	if timeout <= 0 {
+		timeout = m.timeout()
+	}
+	executor := rep.executor
+	pid := executor.Pid()
+	log.Debugf("sending %d activations to pid %d", activations, pid)
+	timingOf(ctx).choose(PathWarm)
+	start := time.Now()
+	response, err := executor.Interact(ctx, line, timeout)
+	if errors.Is(err, ErrOutOfMemory) {
+		m.stats.oomKilled(err)
+	} else {
+		m.stats.record(activations, time.Since(start), err)
+	}
+	m.touch()
+	m.guard(activations)
+	rep.done()
+	if err == nil {
+		atomic.StoreInt32(&rep.restarts, 0)
+	}
+	m.mutex.RUnlock()
+
+	if err != nil {
+		log.Warnf("pid %d failed serving the request: %v", pid, err)
+		m.mutex.Lock()
+		if rep.executor == executor {
+			executor.Stop()
+		}
+		m.mutex.Unlock()
+	}
+	return response, err
+}
+
+// loadTimeout is how long the model can take to load, 0 for the default
+func (m *Model) loadTimeout() time.Duration {
+	return time.Duration(m.LoadTimeoutMS) * time.Millisecond
+}
+
+// timeout is how long the model can take to answer, 0 for the default
+func (m *Model) timeout() time.Duration {
+	return time.Duration(m.TimeoutMS) * time.Millisecond
+}
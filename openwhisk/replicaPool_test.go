@@ -0,0 +1,90 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModel_pickLeastBusy(t *testing.T) {
+	m := newModel(ModelSpec{Name: "fake", Action: "fake", Load: "_test/model.sh"})
+	assert.Nil(t, m.pick())
+	assert.Nil(t, m.scale(map[string]string{}, 3))
+	defer m.stop()
+	assert.Equal(t, 3, m.replicaCount())
+	m.replicas[0].inflight = 2
+	m.replicas[1].inflight = 1
+	m.replicas[2].inflight = 3
+	assert.Equal(t, m.replicas[1], m.pick())
+	assert.Equal(t, int32(2), m.replicas[1].inflight)
+	m.replicas[1].done()
+	m.drop(m.replicas[1])
+	assert.Equal(t, m.replicas[0], m.pick())
+	assert.Equal(t, 2, m.replicaCount())
+}
+
+func TestReplicaPool_scale(t *testing.T) {
+	ap := NewActionProxy("./action/rp", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "fake", Action: "/guest/fake", Load: "_test/model.sh", Cold: "_test/cold.sh"},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	m := ap.models.get("fake")
+
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/fake","replicas":3}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 3, len(m.replicas))
+	assert.NotEqual(t, m.replicas[0].executor.Pid(), m.replicas[1].executor.Pid())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, status, _ := doPost(ts.URL+"/run", `{"action_name":"/guest/fake","value":{}}`)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, `{"model": "fake"}`, strings.TrimSpace(res))
+		}()
+	}
+	wg.Wait()
+
+	// loading again without a count keeps the pool
+	_, status, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/fake"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 3, len(m.replicas))
+
+	res, status, _ := doPost(ts.URL+"/scale", `{"action_name":"/guest/fake","replicas":1}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"ok":true}`, strings.TrimSpace(res))
+	assert.Equal(t, 1, len(m.replicas))
+
+	_, status, _ = doPost(ts.URL+"/scale", `{"action_name":"/guest/fake","replicas":-1}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	_, status, _ = doPost(ts.URL+"/scale", `{"action_name":"/guest/fake","replicas":0}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, m.replicas)
+}
@@ -0,0 +1,116 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned when too many requests are already waiting for a model
+var ErrQueueFull = errors.New("too many requests waiting")
+
+// requestQueue lets a limited number of requests at a time use a model,
+// the others wait in FIFO order
+type requestQueue struct {
+	mutex sync.Mutex
+	// slots is how many requests can use the model at the same time
+	slots int
+	// active is how many requests are using the model
+	active int
+	// waiters are the requests waiting for their turn, in order
+	waiters []chan struct{}
+}
+
+func newRequestQueue() *requestQueue {
+	return &requestQueue{slots: 1}
+}
+
+// enter waits for the turn of the request. If depth is not 0 and
+// depth requests are already waiting, it fails with ErrQueueFull.
+// It also fails if the context is done while waiting.
+func (q *requestQueue) enter(ctx context.Context, depth int) error {
+	q.mutex.Lock()
+	if q.active < q.slots && len(q.waiters) == 0 {
+		q.active++
+		q.mutex.Unlock()
+		return nil
+	}
+	if depth > 0 && len(q.waiters) >= depth {
+		q.mutex.Unlock()
+		return ErrQueueFull
+	}
+	turn := make(chan struct{})
+	q.waiters = append(q.waiters, turn)
+	q.mutex.Unlock()
+
+	select {
+	case <-turn:
+		return nil
+	case <-ctx.Done():
+		q.mutex.Lock()
+		for i, waiter := range q.waiters {
+			if waiter == turn {
+				q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+				q.mutex.Unlock()
+				return ctx.Err()
+			}
+		}
+		q.mutex.Unlock()
+		// the turn arrived meanwhile, give it to the next one
+		q.leave()
+		return ctx.Err()
+	}
+}
+
+// leave gives the turn to the next request
+func (q *requestQueue) leave() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.active--
+	q.grant()
+}
+
+// resize changes how many requests can use the model at the same time
+func (q *requestQueue) resize(slots int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if slots < 1 {
+		slots = 1
+	}
+	q.slots = slots
+	q.grant()
+}
+
+// grant gives the turn to the waiting requests while there are free slots,
+// the caller must hold the mutex
+func (q *requestQueue) grant() {
+	for q.active < q.slots && len(q.waiters) > 0 {
+		close(q.waiters[0])
+		q.waiters = q.waiters[1:]
+		q.active++
+	}
+}
+
+// length is the number of requests using or waiting for the model
+func (q *requestQueue) length() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.active + len(q.waiters)
+}
@@ -0,0 +1,123 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestQueue_fifo(t *testing.T) {
+	q := newRequestQueue()
+	assert.Nil(t, q.enter(context.Background(), 0))
+	order := make(chan int, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q.enter(context.Background(), 0)
+			order <- i
+			q.leave()
+		}(i)
+		// let each request start waiting before the next one
+		for q.length() != i+2 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.leave()
+	wg.Wait()
+	assert.Equal(t, 0, <-order)
+	assert.Equal(t, 1, <-order)
+	assert.Equal(t, 2, <-order)
+}
+
+func TestRequestQueue_depth(t *testing.T) {
+	q := newRequestQueue()
+	assert.Nil(t, q.enter(context.Background(), 1))
+	go q.enter(context.Background(), 1)
+	for q.length() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, ErrQueueFull, q.enter(context.Background(), 1))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.enter(ctx, 0))
+	assert.Equal(t, 2, q.length())
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	ap := NewActionProxy("./action/cr", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "fake", Action: "/guest/fake", Load: "_test/model.sh", Cold: "_test/cold.sh"},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	body := `{"action_name":"/guest/fake","value":{}}`
+	_, status, _ := doPost(ts.URL+"/load", body)
+	assert.Equal(t, http.StatusOK, status)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, status, _ := doPost(ts.URL+"/run", body)
+			assert.Equal(t, http.StatusOK, status)
+			// served warm or cold if it was offloaded
+			assert.Contains(t, []string{`{"model": "fake"}`, `{"model": "cold"}`}, strings.TrimSpace(res))
+		}()
+	}
+	// offloading waits for the runs in progress
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		doPost(ts.URL+"/offload", body)
+	}()
+	wg.Wait()
+	ap.StopAllExecutorsExcept("")
+}
+
+func TestRequestQueue_resize(t *testing.T) {
+	q := newRequestQueue()
+	assert.Nil(t, q.enter(context.Background(), 0))
+	entered := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			q.enter(context.Background(), 0)
+			entered <- true
+		}()
+	}
+	for q.length() != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	// both waiting requests can use the model when it has 3 replicas
+	q.resize(3)
+	<-entered
+	<-entered
+	assert.Equal(t, 3, q.length())
+}
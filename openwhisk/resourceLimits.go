@@ -0,0 +1,379 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// CgroupMount is where the cgroup v2 hierarchy is mounted
+const CgroupMount = "/sys/fs/cgroup"
+
+// cgroupPeriod is the period of the cpu.max quota, in microseconds
+const cgroupPeriod = 100000
+
+// oomStatus prefixes the exit status of the processes killed for exceeding their memory limit
+const oomStatus = "out of memory"
+
+// ErrOutOfMemory is returned when the process of a model was killed for exceeding its memory limit
+var ErrOutOfMemory = fmt.Errorf("%w: out of memory", ErrExecutorExited)
+
+// limited checks if the resources of the processes of the model are limited
+func (spec *ModelSpec) limited() bool {
+	return spec.MemoryMaxMB > 0 || spec.CPUs > 0 || spec.PidsMax > 0 || spec.CPUSet != ""
+}
+
+// resourceLimits bound the resources used by the processes of an executor,
+// with a cgroup when available, or else with setrlimit
+type resourceLimits struct {
+	// memory is the memory limit in bytes, 0 for none
+	memory uint64
+	// cpus is the number of cpus the processes can use, 0 for no limit
+	cpus float64
+	// pids is the highest number of processes, 0 for no limit
+	pids int
+	// cpuset are the cpus the processes can run on, nil for all
+	cpuset cpuSet
+	// parent is where the cgroups are created, nil to use setrlimit
+	parent *cgroupParent
+}
+
+// childLimits are the limits a process applies to itself before running its command,
+// so that it is limited from its start: the cgroup to join, or else the setrlimit fallback
+type childLimits struct {
+	// Path is the command executed once limited, when the proxy is started in its place
+	Path   string `json:"path,omitempty"`
+	Cgroup string `json:"cgroup,omitempty"`
+	// AS limits the address space in bytes, in place of the memory
+	AS uint64 `json:"as,omitempty"`
+	// Nproc limits the processes of the user, in place of the processes of the group
+	Nproc uint64 `json:"nproc,omitempty"`
+}
+
+// prepare creates the cgroup of a process about to start. It returns the cgroup, nil if none,
+// with the limits the process applies to itself, nil for none. Without cgroups it falls back
+// to setrlimit, which only approximates them: RLIMIT_AS bounds the address space rather than
+// the memory used, and RLIMIT_NPROC counts all the processes of the user; the cpus are not limited.
+func (l *resourceLimits) prepare(name string) (*cgroup, *childLimits, error) {
+	if l.memory == 0 && l.cpus == 0 && l.pids == 0 {
+		return nil, nil, nil
+	}
+	errs := []string{}
+	if l.parent != nil {
+		cg, err := l.parent.create(name, l)
+		if err == nil {
+			return cg, &childLimits{Cgroup: cg.dir}, nil
+		}
+		errs = append(errs, fmt.Sprintf("cannot create the cgroup of %s, using setrlimit: %v", name, err))
+	}
+	if l.cpus > 0 {
+		errs = append(errs, fmt.Sprintf("cannot limit the cpus of %s without cgroups", name))
+	}
+	var limits *childLimits
+	if l.memory > 0 || l.pids > 0 {
+		limits = &childLimits{AS: l.memory, Nproc: uint64(l.pids)}
+	}
+	if len(errs) > 0 {
+		return nil, limits, errors.New(strings.Join(errs, "; "))
+	}
+	return nil, limits, nil
+}
+
+// apply restricts the running process to the cpuset, with the processes already in its
+// process group if group is true, and applies them the limits if not nil.
+// Each limit is applied even if another fails, the errors are returned together.
+func (l *resourceLimits) apply(name string, pid int, group bool, limits *childLimits) error {
+	pids := []int{pid}
+	if group {
+		for _, p := range listProcs() {
+			if p.pgrp == pid && p.pid != pid {
+				pids = append(pids, p.pid)
+			}
+		}
+	}
+	errs := []string{}
+	if len(l.cpuset) > 0 {
+		for _, p := range pids {
+			for _, tid := range threads(p) {
+				if err := setAffinity(tid, l.cpuset); err != nil {
+					errs = append(errs, fmt.Sprintf("cannot set the cpus of %s: %v", name, err))
+					break
+				}
+			}
+		}
+	}
+	if limits != nil {
+		if err := limits.apply(pid); err != nil {
+			errs = append(errs, fmt.Sprintf("cannot limit %s: %v", name, err))
+		}
+		// the other processes of the group may have terminated meanwhile
+		for _, p := range pids[1:] {
+			limits.apply(p)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// apply applies the limits to a running process, 0 for the proxy itself.
+// Each limit is applied even if another fails, the errors are returned together.
+func (cl *childLimits) apply(pid int) error {
+	errs := []string{}
+	if cl.Cgroup != "" {
+		if err := writeCgroup(cl.Cgroup, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+			errs = append(errs, fmt.Sprintf("cannot join the cgroup: %v", err))
+		}
+	}
+	if cl.AS > 0 {
+		if err := setrlimit(pid, rlimitAS, cl.AS); err != nil {
+			errs = append(errs, fmt.Sprintf("cannot limit the address space: %v", err))
+		}
+	}
+	if cl.Nproc > 0 {
+		if err := setrlimit(pid, rlimitNproc, cl.Nproc); err != nil {
+			errs = append(errs, fmt.Sprintf("cannot limit the processes: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// LimitEnv passes to the proxy, started in place of a command,
+// the limits to apply to itself before executing the command
+const LimitEnv = "__OW_LIMIT"
+
+// limitCommand has the proxy started in place of the command, to apply the limits
+// to itself before executing the command: the command runs limited from its start
+func limitCommand(cmd *exec.Cmd, limits *childLimits) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	limited := *limits
+	limited.Path = cmd.Path
+	buf, _ := json.Marshal(limited)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, LimitEnv+"="+string(buf))
+	cmd.Path = exe
+	return nil
+}
+
+// ExecLimitedCommand checks if the process was started in place of a command to limit:
+// if it was, it applies the limits to itself and executes the command, never returning.
+// The limits which cannot be applied are reported on the standard error.
+// It must be called before anything else in main, and in TestMain for the tests.
+func ExecLimitedCommand() {
+	config := os.Getenv(LimitEnv)
+	if config == "" {
+		return
+	}
+	var limits childLimits
+	if err := json.Unmarshal([]byte(config), &limits); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid limits: %v\n", err)
+		os.Exit(127)
+	}
+	if err := limits.apply(0); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", limits.Path, err)
+	}
+	os.Unsetenv(LimitEnv)
+	err := syscall.Exec(limits.Path, os.Args, os.Environ())
+	fmt.Fprintf(os.Stderr, "cannot execute %s: %v\n", limits.Path, err)
+	os.Exit(127)
+}
+
+// cgroupParent is the cgroup of the proxy, where the cgroups of the executors are created
+type cgroupParent struct {
+	dir string
+	// count numbers the cgroups created, accessed atomically
+	count int64
+	once  sync.Once
+	err   error
+}
+
+var (
+	systemCgroupOnce sync.Once
+	systemCgroup     *cgroupParent
+)
+
+// systemCgroupParent returns the cgroup v2 of the proxy, if it is writable, or nil
+func systemCgroupParent() *cgroupParent {
+	systemCgroupOnce.Do(func() {
+		systemCgroup = findCgroupParent(CgroupMount, "/proc/self/cgroup")
+	})
+	return systemCgroup
+}
+
+// findCgroupParent finds the cgroup v2 of the proxy in the hierarchy mounted in mount,
+// reading the membership of the proxy in file. It returns nil if it is not writable.
+func findCgroupParent(mount string, file string) *cgroupParent {
+	if _, err := os.Stat(filepath.Join(mount, "cgroup.controllers")); err != nil {
+		executorLog.Debugf("cgroups v2 not available: %v", err)
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+		dir := filepath.Join(mount, strings.TrimPrefix(line, "0::"))
+		// write and search permissions
+		if err := syscall.Access(dir, 0x2|0x1); err != nil {
+			executorLog.Debugf("cgroup %s not writable: %v", dir, err)
+			return nil
+		}
+		return &cgroupParent{dir: dir}
+	}
+	return nil
+}
+
+// enable delegates the controllers to the cgroups of the executors. As a cgroup
+// with processes cannot delegate them, the processes of the proxy are first moved in a leaf.
+func (p *cgroupParent) enable() error {
+	p.once.Do(func() {
+		p.err = p.delegate()
+		if p.err == nil {
+			return
+		}
+		leaf := filepath.Join(p.dir, "ow-proxy")
+		if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+			p.err = err
+			return
+		}
+		procs, err := ioutil.ReadFile(filepath.Join(p.dir, "cgroup.procs"))
+		if err != nil {
+			p.err = err
+			return
+		}
+		for _, pid := range strings.Fields(string(procs)) {
+			writeCgroup(leaf, "cgroup.procs", pid)
+		}
+		p.err = p.delegate()
+	})
+	return p.err
+}
+
+// delegate enables the controllers for the children of the cgroup
+func (p *cgroupParent) delegate() error {
+	for _, controller := range []string{"memory", "cpu", "pids"} {
+		if err := writeCgroup(p.dir, "cgroup.subtree_control", "+"+controller); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var unsafeCgroupName = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// create creates a cgroup for an executor of the named model, with the given limits
+func (p *cgroupParent) create(name string, l *resourceLimits) (*cgroup, error) {
+	if err := p.enable(); err != nil {
+		return nil, err
+	}
+	n := atomic.AddInt64(&p.count, 1)
+	cg := &cgroup{filepath.Join(p.dir, fmt.Sprintf("ow-%s-%d", unsafeCgroupName.ReplaceAllString(name, "_"), n))}
+	if err := os.Mkdir(cg.dir, 0755); err != nil {
+		return nil, err
+	}
+	settings := map[string]string{}
+	if l.memory > 0 {
+		settings["memory.max"] = strconv.FormatUint(l.memory, 10)
+		// kill all the processes together, not just the biggest
+		settings["memory.oom.group"] = "1"
+	}
+	if l.cpus > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(l.cpus*cgroupPeriod), cgroupPeriod)
+	}
+	if l.pids > 0 {
+		settings["pids.max"] = strconv.Itoa(l.pids)
+	}
+	for file, value := range settings {
+		if err := writeCgroup(cg.dir, file, value); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+	return cg, nil
+}
+
+// cgroup is the cgroup of the processes of an executor
+type cgroup struct {
+	dir string
+}
+
+// add moves the process in the cgroup, the processes it spawns will follow
+func (cg *cgroup) add(pid int) error {
+	return writeCgroup(cg.dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// oomKilled checks if processes of the cgroup were killed for exceeding the memory limit
+func (cg *cgroup) oomKilled() bool {
+	data, err := ioutil.ReadFile(filepath.Join(cg.dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" && fields[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the cgroup, waiting a bit for its processes to be gone
+func (cg *cgroup) remove() {
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.RemoveAll(cg.dir); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	executorLog.Warnf("cannot remove cgroup %s: %v", cg.dir, err)
+}
+
+// writeCgroup writes a value in a control file of the cgroup
+func writeCgroup(dir string, file string, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
@@ -0,0 +1,173 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readCgroup(dir string, file string) string {
+	data, _ := ioutil.ReadFile(filepath.Join(dir, file))
+	return string(data)
+}
+
+func TestFindCgroupParent(t *testing.T) {
+	mount, _ := ioutil.TempDir("", "cgroup")
+	defer os.RemoveAll(mount)
+	membership := filepath.Join(mount, "membership")
+	ioutil.WriteFile(membership, []byte("0::/proxy\n"), 0644)
+	os.Mkdir(filepath.Join(mount, "proxy"), 0755)
+
+	// not a cgroup v2 hierarchy
+	assert.Nil(t, findCgroupParent(mount, membership))
+
+	ioutil.WriteFile(filepath.Join(mount, "cgroup.controllers"), []byte("cpu memory pids\n"), 0644)
+	parent := findCgroupParent(mount, membership)
+	assert.NotNil(t, parent)
+	assert.Equal(t, filepath.Join(mount, "proxy"), parent.dir)
+}
+
+func TestCgroup_limits(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cgroup")
+	defer os.RemoveAll(dir)
+	limits := &resourceLimits{100 * megabyte, 0.5, 10, nil, &cgroupParent{dir: dir}}
+
+	cg, child, err := limits.prepare("res/50")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "ow-res_50-1"), cg.dir)
+	assert.Equal(t, &childLimits{Cgroup: cg.dir}, child)
+	assert.Equal(t, "+pids", readCgroup(dir, "cgroup.subtree_control"))
+	assert.Equal(t, "104857600", readCgroup(cg.dir, "memory.max"))
+	assert.Equal(t, "1", readCgroup(cg.dir, "memory.oom.group"))
+	assert.Equal(t, "50000 100000", readCgroup(cg.dir, "cpu.max"))
+	assert.Equal(t, "10", readCgroup(cg.dir, "pids.max"))
+	assert.Nil(t, limits.apply("res/50", 1234, false, child))
+	assert.Equal(t, "1234", readCgroup(cg.dir, "cgroup.procs"))
+
+	assert.False(t, cg.oomKilled())
+	ioutil.WriteFile(filepath.Join(cg.dir, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0644)
+	assert.True(t, cg.oomKilled())
+
+	cg.remove()
+	_, err = os.Stat(cg.dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCgroup_affinityFailure(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cgroup")
+	defer os.RemoveAll(dir)
+	limits := &resourceLimits{memory: 100 * megabyte, cpuset: cpuSet{100000}, parent: &cgroupParent{dir: dir}}
+
+	// the cgroup is applied even if the cpus cannot be set
+	cg, child, _ := limits.prepare("res")
+	err := limits.apply("res", 1234, false, child)
+	assert.Contains(t, err.Error(), "cannot set the cpus of res")
+	assert.Equal(t, "1234", readCgroup(cg.dir, "cgroup.procs"))
+}
+
+func TestLimits_rlimit(t *testing.T) {
+	proc := NewModelExecutor(nil, nil, "fake", true, "_test/model.sh", map[string]string{})
+	proc.limits = &resourceLimits{memory: 512 * megabyte, cpus: 1}
+	assert.Nil(t, proc.Start(false, 0))
+	defer proc.Stop()
+	assert.Nil(t, proc.cgroup)
+
+	// without cgroups the address space is limited in place of the memory
+	limits, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/limits", proc.Pid()))
+	for _, line := range strings.Split(string(limits), "\n") {
+		if strings.HasPrefix(line, "Max address space") {
+			assert.Equal(t, []string{"536870912", "536870912", "bytes"}, strings.Fields(line)[3:])
+			_, _, err := proc.limits.prepare("fake")
+			assert.Equal(t, "cannot limit the cpus of fake without cgroups", err.Error())
+			return
+		}
+	}
+	t.Fatal("address space limit not found")
+}
+
+func TestLimits_beforeExec(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cgroup")
+	defer os.RemoveAll(dir)
+	proc := NewModelExecutor(nil, nil, "fake", true, "_test/model.sh", map[string]string{})
+	proc.limits = &resourceLimits{memory: 100 * megabyte, parent: &cgroupParent{dir: dir}}
+	assert.Nil(t, proc.Start(false, 0))
+	defer proc.Stop()
+
+	// the process joined its cgroup itself, before executing the command
+	_, err := proc.Interact(context.Background(), []byte(`{}`), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "0", readCgroup(proc.cgroup.dir, "cgroup.procs"))
+	cmdline, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", proc.Pid()))
+	assert.Contains(t, string(cmdline), "_test/model.sh")
+	environ, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", proc.Pid()))
+	assert.NotContains(t, string(environ), LimitEnv)
+}
+
+func TestLimits_outOfMemory(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cgroup")
+	defer os.RemoveAll(dir)
+	proc := NewModelExecutor(nil, nil, "crash", true, "_test/crash.sh", map[string]string{})
+	proc.limits = &resourceLimits{memory: 100 * megabyte, parent: &cgroupParent{dir: dir}}
+	assert.Nil(t, proc.Start(false, 0))
+	defer proc.Stop()
+	assert.NotNil(t, proc.cgroup)
+
+	// the kernel records the kill in the cgroup
+	ioutil.WriteFile(filepath.Join(proc.cgroup.dir, "memory.events"), []byte("oom_kill 1\n"), 0644)
+	_, err := proc.Interact(context.Background(), []byte(`{"crash": true}`), 0)
+	assert.True(t, errors.Is(err, ErrOutOfMemory))
+	assert.True(t, errors.Is(err, ErrExecutorExited))
+	assert.True(t, strings.HasPrefix(proc.ExitStatus(), "out of memory, exit status"))
+}
+
+func TestLimits_coldOutOfMemory(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cgroup")
+	defer os.RemoveAll(dir)
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{{Name: "oom", Action: "/guest/oom", Load: "_test/model.sh", Cold: "_test/die.sh"}})
+	ap.models.get("oom").limits = &resourceLimits{memory: 100 * megabyte, parent: &cgroupParent{dir: dir}}
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+
+	// the kernel records the kill in the cgroup of the cold run
+	go func() {
+		for i := 0; i < 100; i++ {
+			if cgroups, _ := filepath.Glob(filepath.Join(dir, "ow-oom-*")); len(cgroups) > 0 {
+				ioutil.WriteFile(filepath.Join(cgroups[0], "memory.events"), []byte("oom_kill 1\n"), 0644)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	body, status, _ := doPost(ts.URL+"/run", `{"action_name":"/guest/oom","value":{}}`)
+	assert.Equal(t, http.StatusInsufficientStorage, status)
+	assert.Contains(t, body, "out of memory")
+}
@@ -1,3 +1,6 @@
+//go:build !linux
+// +build !linux
+
 /*
  * Licensed to the Apache Software Foundation (ASF) under one or more
  * contributor license agreements.  See the NOTICE file distributed with
@@ -14,25 +17,17 @@
  * See the License for the specific language governing permissions and
  * limitations under the License.
  */
-package openwhisk
 
-import "log"
+package openwhisk
 
-// Debugging flag
-var Debugging = false
+import "errors"
 
-// Debug emits a debug message
-func Debug(format string, args ...interface{}) {
-	if Debugging {
-		log.Printf(format, args...)
-	}
-}
+const (
+	rlimitAS    = 0
+	rlimitNproc = 0
+)
 
-// DebugLimit emits a debug message with a limit in length
-func DebugLimit(msg string, in []byte, limit int) {
-	if len(in) < limit {
-		Debug("%s:%s", msg, in)
-	} else {
-		Debug("%s:%s...", msg, in[0:limit])
-	}
+// setrlimit is not supported on other processes outside Linux
+func setrlimit(pid int, resource int, limit uint64) error {
+	return errors.New("setrlimit of another process not supported")
 }
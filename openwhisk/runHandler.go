@@ -20,6 +20,7 @@ package openwhisk
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -36,7 +37,7 @@ func sendError(w http.ResponseWriter, code int, cause string) {
 	b, err := json.Marshal(errResponse)
 	if err != nil {
 		b = []byte("error marshalling error response")
-		Debug(err.Error())
+		httpLog.Errorf("cannot marshal the error response: %v", err)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -45,6 +46,7 @@ func sendError(w http.ResponseWriter, code int, cause string) {
 }
 
 func (ap *ActionProxy) runHandler(w http.ResponseWriter, r *http.Request) {
+	log := requestLog(r.Context())
 
 	// parse the request
 	body, err1 := ioutil.ReadAll(r.Body)
@@ -55,7 +57,7 @@ func (ap *ActionProxy) runHandler(w http.ResponseWriter, r *http.Request) {
 		sendError(w, http.StatusBadRequest, fmt.Sprintf("Error reading request body: %v", err1))
 		return
 	}
-	Debug("runHandler done reading %d bytes", len(body))
+	log.Debugf("runHandler done reading %d bytes", len(body))
 
 	// remove newlines
 	body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
@@ -68,111 +70,78 @@ func (ap *ActionProxy) runHandler(w http.ResponseWriter, r *http.Request) {
 	var req requestBody
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		Debug("Meet error:")
-		Debug(err.Error())
+		log.Errorf("cannot decode the request: %v", err)
 		return
 	}
-	actionName := req.ActionName
-	if strings.Contains(actionName, "ptest04") && (ap.theresnet18Executor.started == false) {
-		//NEWresnet18Executor1 := Newresnet18Executor(ap.outFile, ap.errFile, "_test/loadres18.sh", ap.env)
-		//ap.theresnet18Executor = NEWresnet18Executor1
-		Debug("has created res18executor")
-		ap.StopAllExecutorsExcept("none")
-		response, err = ap.theOriginresnet18Executor.StartAndWaitForOutput()
-
-		//create new executor
-		NEWOriginresnet18Executor := NewOriginresnet18Executor(ap.outFile, ap.errFile, "_test/func18.sh", ap.env)
-		ap.theOriginresnet18Executor = NEWOriginresnet18Executor
-
-	} else if strings.Contains(actionName, "ptest05") && (ap.theresnet50Executor.started == false) {
-		//NEWresnet50Executor1 := Newresnet50Executor(ap.outFile, ap.errFile, "_test/loadres50.sh", ap.env)
-		//ap.theresnet50Executor = NEWresnet50Executor1
-		Debug("has created res50executor")
-		ap.StopAllExecutorsExcept("none")
-		response, err = ap.theOriginresnet50Executor.StartAndWaitForOutput()
-
-		//create new executor
-		NEWOriginresnet50Executor := NewOriginresnet50Executor(ap.outFile, ap.errFile, "_test/func50.sh", ap.env)
-		ap.theOriginresnet50Executor = NEWOriginresnet50Executor
-
-	} else if strings.Contains(actionName, "ptest06") && (ap.theresnet152Executor.started == false) {
-		//NEWresnet152Executor1 := Newresnet152Executor(ap.outFile, ap.errFile, "_test/loadres152.sh", ap.env)
-		//ap.theresnet152Executor = NEWresnet152Executor1
-		Debug("has created res152executor")
-		ap.StopAllExecutorsExcept("none")
-		response, err = ap.theOriginresnet152Executor.StartAndWaitForOutput()
-
-		//create new executor
-		NEWOriginresnet152Executor := NewOriginresnet152Executor(ap.outFile, ap.errFile, "_test/func152.sh", ap.env)
-		ap.theOriginresnet152Executor = NEWOriginresnet152Executor
-
-	} else if strings.Contains(actionName, "ptest01") && (ap.thealexExecutor.started == false) {
-		Debug("has created alexexecutor")
-		ap.StopAllExecutorsExcept("none")
-		response, err = ap.theOriginalexExecutor.StartAndWaitForOutput()
-		//create new executor
-		NEWOriginalexExecutor := NewOriginalexExecutor(ap.outFile, ap.errFile, "_test/funcalex.sh", ap.env)
-		ap.theOriginalexExecutor = NEWOriginalexExecutor
-	} else if strings.Contains(actionName, "ptest02") && (ap.thevggExecutor.started == false) {
-		Debug("has created vggexecutor")
-		ap.StopAllExecutorsExcept("none")
-		response, err = ap.theOriginvggExecutor.StartAndWaitForOutput()
-		//create new executor
-		NEWOriginvggExecutor := NewOriginvggExecutor(ap.outFile, ap.errFile, "_test/funcvgg.sh", ap.env)
-		ap.theOriginvggExecutor = NEWOriginvggExecutor
-	} else if strings.Contains(actionName, "ptest03") && (ap.theinceptionExecutor.started == false) {
-		Debug("has created inceptionexecutor")
-		ap.StopAllExecutorsExcept("none")
-		response, err = ap.theOrigininceptionExecutor.StartAndWaitForOutput()
-		//create new executor
-		NEWOrigininceptionExecutor := NewOrigininceptionExecutor(ap.outFile, ap.errFile, "_test/funcinception.sh", ap.env)
-		ap.theOrigininceptionExecutor = NEWOrigininceptionExecutor
-	} else if strings.Contains(actionName, "ptest07") && (ap.thegooglenetExecutor.started == false) {
-		Debug("has created googlenetexecutor")
-		ap.StopAllExecutorsExcept("none")
-		response, err = ap.theOrigingooglenetExecutor.StartAndWaitForOutput()
-		//create new executor
-		NEWOrigingooglenetExecutor := NewOrigingooglenetExecutor(ap.outFile, ap.errFile, "_test/funcgooglenet.sh", ap.env)
-		ap.theOrigingooglenetExecutor = NEWOrigingooglenetExecutor
-	} else if strings.Contains(actionName, "ptest08") && (ap.thebertExecutor.started == false) {
-		Debug("has created bertexecutor")
-		ap.StopAllExecutorsExcept("none")
-		response, err = ap.theOriginbertExecutor.StartAndWaitForOutput()
-		//create new executor
-		NEWOriginbertExecutor := NewOriginbertExecutor(ap.outFile, ap.errFile, "_test/funcbert.sh", ap.env)
-		ap.theOriginbertExecutor = NEWOriginbertExecutor
+	m := ap.models.match(req.ActionName)
+	if m != nil && m.hasCold() {
+		log.With("model", m.Name).Debugf("cold run")
+		// bounded by the timeout of the request, as a preloaded model
+		timeout, terr := requestTimeout(r, &req, m)
+		if terr != nil {
+			sendError(w, http.StatusBadRequest, terr.Error())
+			return
+		}
+		if ap.memoryBudget == 0 {
+			ap.StopAllExecutorsExcept(m.Name)
+		}
+		reserved, status, rerr := ap.makeRoom(m, 1)
+		if rerr != nil {
+			sendError(w, status, rerr.Error())
+			return
+		}
+		response, err = m.coldRun(r.Context(), ap.env, timeout)
+		ap.release(reserved)
+		if errors.Is(err, ErrTimeout) {
+			sendError(w, http.StatusGatewayTimeout, fmt.Sprintf("%s: %v", m.Name, err))
+			return
+		}
+		// the cold command exceeded its memory limit
+		if errors.Is(err, ErrOutOfMemory) {
+			log.With("model", m.Name).Warnf("out of memory")
+			sendError(w, http.StatusInsufficientStorage, fmt.Sprintf("%s: %v", m.Name, err))
+			return
+		}
 	} else {
+		executor := ap.getExecutor()
 		// check if you have an action
-		if ap.theExecutor == nil {
+		if executor == nil {
 			sendError(w, http.StatusInternalServerError, fmt.Sprintf("no action defined yet"))
 			return
 		}
 		// check if the process exited
-		if ap.theExecutor.Exited() {
+		if executor.Exited() {
 			sendError(w, http.StatusInternalServerError, fmt.Sprintf("command exited"))
 			return
 		}
 
-		response, err = ap.theExecutor.Interact(body)
+		timingOf(r.Context()).choose(PathGeneric)
+		response, err = executor.Interact(body)
+		timingOf(r.Context()).mark(PhaseFirstByte)
+		if err != nil {
+			ap.dropExecutor(executor)
+		}
 	}
 
-	// execute the action
-
 	// check for early termination
 	if err != nil {
-		Debug("WARNING! Command exited (runHandler). Error is: ")
-		Debug(string(err.Error()))
-		ap.theExecutor = nil
+		log.Errorf("command exited: %v", err)
 		sendError(w, http.StatusBadRequest, fmt.Sprintf("command exited"))
 		return
 	}
-	DebugLimit("received:", response, 120)
+	log.DebugLimit("received:", response, 120)
+
+	writeResponse(w, response)
+}
 
+// writeResponse checks the answer of the action is a dictionary
+// and writes it as the response
+func writeResponse(w http.ResponseWriter, response []byte) {
 	// check if the answer is an object map
 	var objmap map[string]*json.RawMessage
 	resStr := strings.ReplaceAll(string(response), "'", "\"")
 	response = []byte(resStr)
-	err = json.Unmarshal(response, &objmap)
+	err := json.Unmarshal(response, &objmap)
 	if err != nil {
 		sendError(w, http.StatusBadGateway, "The action did not return a dictionary.")
 		return
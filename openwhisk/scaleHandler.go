@@ -0,0 +1,65 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// scaleHandler resizes the pool of replicas of a model,
+// scaling to 0 replicas offloads it
+func (ap *ActionProxy) scaleHandler(w http.ResponseWriter, r *http.Request) {
+
+	// parse the request
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Error reading request body: %v", err))
+		return
+	}
+
+	var req requestBody
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Error reading request body: %v", err))
+		return
+	}
+	m := ap.models.match(req.ActionName)
+	if m == nil {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Not defined this model!"))
+		return
+	}
+	if req.Replicas < 0 {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid number of replicas: %d", req.Replicas))
+		return
+	}
+
+	log := requestLog(r.Context()).With("model", m.Name)
+	log.Debugf("scaling to %d replicas", req.Replicas)
+	job := ap.startLoad(m, req.Replicas)
+	<-job.done
+	if job.err != nil {
+		log.Warnf("cannot scale: %v", job.err)
+		sendError(w, job.status, job.err.Error())
+		return
+	}
+	sendOK(w)
+}
@@ -0,0 +1,246 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package openwhisk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"runtime"
+	"time"
+)
+
+// SimulateEnv carries the simulated model to the process playing it
+const SimulateEnv = "__OW_SIMULATE"
+
+// Distributions of the inference latency of a simulated model
+const (
+	DistributionConstant    = "constant"
+	DistributionUniform     = "uniform"
+	DistributionNormal      = "normal"
+	DistributionExponential = "exponential"
+)
+
+// SimulatedModel describes a model imitated by the proxy itself,
+// speaking the same protocol as a real one without any of its libraries
+type SimulatedModel struct {
+	// ImportMS is how long importing the libraries takes, in milliseconds
+	ImportMS int `json:"import_ms,omitempty"`
+	// LoadMS is how long loading the weights takes, in milliseconds
+	LoadMS int `json:"load_ms,omitempty"`
+	// MemoryMB is the memory allocated and touched loading the model, in megabytes
+	MemoryMB uint64 `json:"memory_mb,omitempty"`
+	// InferenceMS is the mean latency of an inference, in milliseconds
+	InferenceMS float64 `json:"inference_ms,omitempty"`
+	// InferenceJitterMS is the half width of the uniform distribution
+	// or the standard deviation of the normal one, in milliseconds
+	InferenceJitterMS float64 `json:"inference_jitter_ms,omitempty"`
+	// Distribution of the inference latency: constant, the default, uniform, normal or exponential
+	Distribution string `json:"distribution,omitempty"`
+	// FailureRate is the probability a request crashes the process, between 0 and 1
+	FailureRate float64 `json:"failure_rate,omitempty"`
+	// Seed makes the latencies and the failures reproducible, 0 for a random one
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// simulation is what the process playing a simulated model receives
+type simulation struct {
+	SimulatedModel
+	Name string `json:"name"`
+	// Ack tells the process to acknowledge when loaded
+	Ack bool `json:"ack,omitempty"`
+}
+
+// check validates the simulated model
+func (sim *SimulatedModel) check() error {
+	if sim.ImportMS < 0 || sim.LoadMS < 0 || sim.InferenceMS < 0 || sim.InferenceJitterMS < 0 {
+		return fmt.Errorf("negative import_ms, load_ms, inference_ms or inference_jitter_ms")
+	}
+	if sim.FailureRate < 0 || sim.FailureRate > 1 {
+		return fmt.Errorf("failure_rate %v not between 0 and 1", sim.FailureRate)
+	}
+	switch sim.Distribution {
+	case "", DistributionConstant, DistributionUniform, DistributionNormal, DistributionExponential:
+		return nil
+	}
+	return fmt.Errorf("unknown distribution %s", sim.Distribution)
+}
+
+// latency draws the duration of an inference
+func (sim *SimulatedModel) latency(rnd *rand.Rand) time.Duration {
+	ms := sim.InferenceMS
+	switch sim.Distribution {
+	case DistributionUniform:
+		ms += (2*rnd.Float64() - 1) * sim.InferenceJitterMS
+	case DistributionNormal:
+		ms += rnd.NormFloat64() * sim.InferenceJitterMS
+	case DistributionExponential:
+		ms *= rnd.ExpFloat64()
+	}
+	if ms < 0 {
+		ms = 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// command is the command, with its environment and arguments, the model runs to load itself,
+// or for a cold run; a simulated model runs the proxy itself, playing it
+func (spec *ModelSpec) command(command string, cold bool, env map[string]string) (string, map[string]string, []string) {
+	if spec.Simulate == nil {
+		return command, env, spec.Args
+	}
+	buf, _ := json.Marshal(simulation{*spec.Simulate, spec.Name, spec.Ack})
+	env[SimulateEnv] = string(buf)
+	args := []string{}
+	if cold {
+		args = append(args, "cold")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	return exe, env, args
+}
+
+// PlaySimulatedModel checks if the process was started to play a simulated model:
+// if it was, it plays the model until its input is closed, then exits.
+// It must be called before anything else in main, and in TestMain for the tests.
+func PlaySimulatedModel() {
+	config := os.Getenv(SimulateEnv)
+	if config == "" {
+		return
+	}
+	var sim simulation
+	if err := json.Unmarshal([]byte(config), &sim); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid simulated model: %v\n", err)
+		os.Exit(1)
+	}
+	out := os.NewFile(3, "pipe")
+	cold := len(os.Args) > 1 && os.Args[1] == "cold"
+	os.Exit(sim.play(os.Stdin, out, cold, requestedProtocol(map[string]string{ProtocolEnv: os.Getenv(ProtocolEnv)})))
+}
+
+// play imitates the model reading the requests from in and writing the answers and the phases to out,
+// returning the exit code of the process
+func (sim *simulation) play(in io.Reader, out io.Writer, cold bool, protocol int) int {
+	seed := sim.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	// until the acknowledgement the messages are lines, in any version
+	sim.phase(out, ProtocolV1, "import", time.Duration(sim.ImportMS)*time.Millisecond)
+	var memory []byte
+	start := time.Now()
+	if sim.MemoryMB > 0 {
+		// the pages are touched to be resident
+		memory = make([]byte, sim.MemoryMB*megabyte)
+		for i := 0; i < len(memory); i += os.Getpagesize() {
+			memory[i] = 1
+		}
+	}
+	sim.phase(out, ProtocolV1, "load", time.Duration(sim.LoadMS)*time.Millisecond-time.Since(start))
+	if cold {
+		answer, ok := sim.infer(rnd, out, ProtocolV1, nil)
+		if !ok {
+			return 1
+		}
+		fmt.Fprintf(out, "%s\n", answer)
+		return 0
+	}
+	if sim.Ack || protocol == ProtocolV2 {
+		ack := ActionAck{Ok: true}
+		if protocol == ProtocolV2 {
+			ack.Protocol = ProtocolV2
+		}
+		buf, _ := json.Marshal(ack)
+		fmt.Fprintf(out, "%s\n", buf)
+	}
+	reader := bufio.NewReader(in)
+	for {
+		var id uint64
+		var request []byte
+		var err error
+		if protocol == ProtocolV2 {
+			id, request, err = readFrame(reader)
+		} else {
+			request, err = reader.ReadBytes('\n')
+		}
+		if err != nil {
+			break
+		}
+		answer, ok := sim.infer(rnd, out, protocol, request)
+		if !ok {
+			return 1
+		}
+		if protocol == ProtocolV2 {
+			writeFrame(out, id, answer)
+		} else {
+			fmt.Fprintf(out, "%s\n", answer)
+		}
+	}
+	// the memory is held until the model is stopped
+	runtime.KeepAlive(memory)
+	return 0
+}
+
+// infer imitates an inference of the request, answering a batch with an array of the same length;
+// it returns false if the inference failed
+func (sim *simulation) infer(rnd *rand.Rand, out io.Writer, protocol int, request []byte) ([]byte, bool) {
+	latency := sim.latency(rnd)
+	if rnd.Float64() < sim.FailureRate {
+		time.Sleep(latency)
+		fmt.Fprintf(os.Stderr, "%s: simulated failure\n", sim.Name)
+		return nil, false
+	}
+	sim.phase(out, protocol, "infer", latency)
+	answer := map[string]interface{}{"model": sim.Name, "simulated": true, "inference_ms": float64(latency) / float64(time.Millisecond)}
+	var batch []json.RawMessage
+	if bytes.HasPrefix(bytes.TrimSpace(request), []byte("[")) && json.Unmarshal(request, &batch) == nil {
+		answers := make([]interface{}, len(batch))
+		for i := range answers {
+			answers[i] = answer
+		}
+		buf, _ := json.Marshal(answers)
+		return buf, true
+	}
+	buf, _ := json.Marshal(answer)
+	return buf, true
+}
+
+// phase spends the given time in the named phase, then reports it
+func (sim *simulation) phase(out io.Writer, protocol int, name string, d time.Duration) {
+	start := time.Now()
+	if d > 0 {
+		time.Sleep(d)
+	}
+	buf, _ := json.Marshal(executorPhase{name, seconds(start), seconds(time.Now())})
+	if protocol == ProtocolV2 {
+		writeFrame(out, 0, buf)
+	} else {
+		fmt.Fprintf(out, "%s %s\n", PhaseMarker, buf)
+	}
+}
+
+// seconds is the time in seconds since the epoch
+func seconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,144 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package openwhisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulatedModel_latency(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	sim := SimulatedModel{InferenceMS: 10, InferenceJitterMS: 5}
+	assert.Equal(t, 10*time.Millisecond, sim.latency(rnd))
+	sim.Distribution = DistributionUniform
+	for i := 0; i < 100; i++ {
+		d := sim.latency(rnd)
+		assert.True(t, d >= 5*time.Millisecond && d <= 15*time.Millisecond)
+	}
+	sim.Distribution = DistributionNormal
+	sim.InferenceJitterMS = 100
+	for i := 0; i < 100; i++ {
+		assert.True(t, sim.latency(rnd) >= 0)
+	}
+	sim.Distribution = DistributionExponential
+	var total time.Duration
+	for i := 0; i < 1000; i++ {
+		total += sim.latency(rnd)
+	}
+	assert.InDelta(t, 10, float64(total/1000)/float64(time.Millisecond), 2)
+}
+
+func TestSimulatedModel_validate(t *testing.T) {
+	assert.Nil(t, ValidateModels([]ModelSpec{
+		{Name: "sim", Action: "/guest/sim", Simulate: &SimulatedModel{LoadMS: 10, Distribution: DistributionNormal}},
+	}))
+	err := ValidateModels([]ModelSpec{
+		{Name: "rate", Action: "/guest/rate", Simulate: &SimulatedModel{FailureRate: 2}},
+		{Name: "dist", Action: "/guest/dist", Simulate: &SimulatedModel{Distribution: "pareto"}},
+		{Name: "zygote", Action: "/guest/zygote", Zygote: true, Simulate: &SimulatedModel{}},
+	})
+	assert.Equal(t, "model rate: simulate: failure_rate 2 not between 0 and 1; "+
+		"model dist: simulate: unknown distribution pareto; "+
+		"model zygote: a simulated model cannot use the zygote or the stem cells", err.Error())
+}
+
+func TestSimulatedModel_play(t *testing.T) {
+	sim := simulation{SimulatedModel{Seed: 1}, "sim", true}
+	var out bytes.Buffer
+	code := sim.play(strings.NewReader("{}\n[{},{}]\n"), &out, false, ProtocolV1)
+	assert.Equal(t, 0, code)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 7, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], PhaseMarker+` {"phase":"import"`))
+	assert.True(t, strings.HasPrefix(lines[1], PhaseMarker+` {"phase":"load"`))
+	assert.Equal(t, `{"ok":true}`, lines[2])
+	assert.True(t, strings.HasPrefix(lines[3], PhaseMarker+` {"phase":"infer"`))
+	assert.Equal(t, `{"inference_ms":0,"model":"sim","simulated":true}`, lines[4])
+	var batch []map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[6]), &batch))
+	assert.Equal(t, 2, len(batch))
+
+	// a failure terminates the process without answering
+	sim.FailureRate = 1
+	out.Reset()
+	assert.Equal(t, 1, sim.play(strings.NewReader("{}\n"), &out, false, ProtocolV1))
+	assert.False(t, strings.Contains(out.String(), `"model"`))
+}
+
+func TestSimulatedModel_executor(t *testing.T) {
+	for _, protocol := range []int{ProtocolV1, ProtocolV2} {
+		m := newModel(ModelSpec{Name: "sim", Action: "/guest/sim", Ack: true, Protocol: protocol,
+			Simulate: &SimulatedModel{LoadMS: 50, MemoryMB: 64, InferenceMS: 10}})
+		executor := m.newExecutor(nil)
+		start := time.Now()
+		assert.Nil(t, executor.Start(true, 0))
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+		// the simulated model is resident in its own process
+		assert.True(t, groupRSS(executor.Pid()) >= 64*megabyte)
+		out, err := executor.Interact(context.Background(), []byte(`{"value":{}}`), 0)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"inference_ms":10,"model":"sim","simulated":true}`, string(out))
+		executor.Stop()
+	}
+
+	// a failure crashes the process
+	m := newModel(ModelSpec{Name: "fail", Action: "/guest/fail", Simulate: &SimulatedModel{FailureRate: 1}})
+	executor := m.newExecutor(nil)
+	assert.Nil(t, executor.Start(false, 0))
+	_, err := executor.Interact(context.Background(), []byte(`{"value":{}}`), 0)
+	assert.NotNil(t, err)
+	<-executor.Done()
+	assert.Equal(t, "exit status 1", executor.ExitStatus())
+}
+
+func TestSimulatedModel_proxy(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "sim", Action: "/guest/sim", Ack: true,
+			Simulate: &SimulatedModel{ImportMS: 20, LoadMS: 30, InferenceMS: 5}},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	// a cold run goes through all the phases
+	names, phases := postPhases(t, ts, `{"action_name":"/guest/sim","value":{}}`)
+	assert.Equal(t, []string{"import", "load", "infer"}, names)
+	assert.True(t, phases["import"] >= 20)
+	assert.True(t, phases["load"] >= 30)
+
+	// then the model is loaded and served warm
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/sim"}`)
+	assert.Equal(t, http.StatusOK, status)
+	res, status, _ := doPost(ts.URL+"/run", `{"action_name":"/guest/sim","value":{}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, res, `"simulated":true`)
+	samples := getMetrics(t, ts)
+	assert.Equal(t, "1", samples[`openwhisk_runs_total{model="sim",mode="cold"}`])
+	assert.Equal(t, "1", samples[`openwhisk_runs_total{model="sim",mode="warm"}`])
+}
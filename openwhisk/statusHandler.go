@@ -0,0 +1,138 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// proxyStatus is the answer to /status
+type proxyStatus struct {
+	Version     string        `json:"version"`
+	Initialized bool          `json:"initialized"`
+	ActionDir   string        `json:"action_dir,omitempty"`
+	StemCells   int           `json:"stem_cells,omitempty"`
+	Models      []modelStatus `json:"models"`
+}
+
+// modelStatus describes a model and its replicas: the pid, memory and uptime
+// are the ones of the oldest replica ready, the memory is the total
+type modelStatus struct {
+	Name          string          `json:"name"`
+	State         ExecutorState   `json:"state"`
+	Pid           int             `json:"pid,omitempty"`
+	RSS           uint64          `json:"rss_bytes"`
+	UptimeMS      int64           `json:"uptime_ms"`
+	Served        uint64          `json:"served"`
+	LastLatencyMS float64         `json:"last_latency_ms"`
+	AvgLatencyMS  float64         `json:"avg_latency_ms"`
+	LastError     string          `json:"last_error,omitempty"`
+	OOMKills      uint64          `json:"oom_kills"`
+	KeepAliveMS   int64           `json:"keep_alive_ms,omitempty"`
+	ExpiresAt     *time.Time      `json:"expires_at,omitempty"`
+	Replicas      []replicaStatus `json:"replicas"`
+}
+
+// replicaStatus describes a replica of a model
+type replicaStatus struct {
+	State    ExecutorState `json:"state"`
+	Pid      int           `json:"pid,omitempty"`
+	RSS      uint64        `json:"rss_bytes"`
+	UptimeMS int64         `json:"uptime_ms"`
+	Inflight int32         `json:"inflight"`
+	Restarts int32         `json:"restarts"`
+	// ExitStatus is how the process terminated, if it did
+	ExitStatus string `json:"exit_status,omitempty"`
+}
+
+// statusHandler describes the proxy and the state of every model
+func (ap *ActionProxy) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s not allowed on /status", r.Method))
+		return
+	}
+	res := proxyStatus{
+		Version:     Version,
+		Initialized: ap.initialized,
+		Models:      []modelStatus{},
+	}
+	if ap.initialized {
+		res.ActionDir = fmt.Sprintf("%s/%d", ap.baseDir, ap.currentDir)
+	}
+	if ap.stemCells != nil {
+		res.StemCells = ap.stemCells.idle()
+	}
+	for _, m := range ap.models.all() {
+		res.Models = append(res.Models, m.status())
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+	w.Write([]byte("\n"))
+}
+
+// status describes the model and its replicas
+func (m *Model) status() modelStatus {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	res := modelStatus{Name: m.Name, State: m.state(), Replicas: []replicaStatus{}}
+	for _, rep := range m.replicas {
+		rs := replicaStatus{
+			State:      rep.state(),
+			Pid:        rep.executor.Pid(),
+			Inflight:   atomic.LoadInt32(&rep.inflight),
+			Restarts:   atomic.LoadInt32(&rep.restarts),
+			ExitStatus: rep.executor.ExitStatus(),
+		}
+		if rs.State == StateReady || rs.State == StateBusy {
+			rs.RSS = groupRSS(rs.Pid)
+			rs.UptimeMS = time.Since(rep.since).Milliseconds()
+			res.RSS += rs.RSS
+			if rs.UptimeMS > res.UptimeMS {
+				res.Pid, res.UptimeMS = rs.Pid, rs.UptimeMS
+			}
+		}
+		res.Replicas = append(res.Replicas, rs)
+	}
+	res.KeepAliveMS = m.keepAliveTTL().Milliseconds()
+	if expiry := m.expiry(); !expiry.IsZero() {
+		res.ExpiresAt = &expiry
+	}
+
+	m.stats.mutex.Lock()
+	defer m.stats.mutex.Unlock()
+	res.Served = m.stats.served
+	res.LastLatencyMS = milliseconds(m.stats.last)
+	res.AvgLatencyMS = milliseconds(m.stats.average())
+	res.LastError = m.stats.lastError
+	res.OOMKills = m.stats.oomKills
+	return res
+}
+
+// milliseconds converts a duration in fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getStatus(t *testing.T, ts *httptest.Server) proxyStatus {
+	res, err := http.Get(ts.URL + "/status")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, _ := ioutil.ReadAll(res.Body)
+	var status proxyStatus
+	assert.Nil(t, json.Unmarshal(body, &status))
+	return status
+}
+
+func TestStatus(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "fake", Action: "/guest/fake", Load: "_test/model.sh", Cold: "_test/cold.sh"},
+		{Name: "crash", Action: "/guest/crash", Load: "_test/crash.sh", Cold: "_test/cold.sh", MaxRestarts: -1},
+	})
+	// keep both models loaded
+	ap.SetMemoryBudget(1024)
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	status := getStatus(t, ts)
+	assert.Equal(t, Version, status.Version)
+	assert.False(t, status.Initialized)
+	assert.Equal(t, 2, len(status.Models))
+	assert.Equal(t, StateUnloaded, status.Models[0].State)
+	assert.Equal(t, 0, status.Models[0].Pid)
+
+	_, code, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/fake","replicas":2}`)
+	assert.Equal(t, http.StatusOK, code)
+	_, code, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/fake","value":{}}`)
+	assert.Equal(t, http.StatusOK, code)
+	_, code, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/crash"}`)
+	assert.Equal(t, http.StatusOK, code)
+	_, code, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/crash","value":{"crash":true}}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	status = getStatus(t, ts)
+	fake := status.Models[0]
+	assert.Equal(t, "fake", fake.Name)
+	assert.Equal(t, StateReady, fake.State)
+	assert.NotEqual(t, 0, fake.Pid)
+	assert.Equal(t, 2, len(fake.Replicas))
+	assert.Equal(t, uint64(1), fake.Served)
+	assert.True(t, fake.LastLatencyMS > 0)
+	assert.Equal(t, fake.LastLatencyMS, fake.AvgLatencyMS)
+	assert.Empty(t, fake.LastError)
+
+	// the crashed replica was retried with a cold run, and is not restarted
+	crash := status.Models[1]
+	assert.Equal(t, "crash", crash.Name)
+	assert.Equal(t, uint64(1), crash.Served)
+	assert.Contains(t, crash.LastError, "exited")
+
+	_, code, _ = doPost(ts.URL+"/status", `{}`)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
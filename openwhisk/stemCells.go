@@ -0,0 +1,217 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// specialization tells a stem cell the model to load
+type specialization struct {
+	Name       string            `json:"name"`
+	Load       string            `json:"load"`
+	Args       []string          `json:"args,omitempty"`
+	Dir        string            `json:"dir,omitempty"`
+	Env        map[string]string `json:"env"`
+	Entrypoint string            `json:"entrypoint,omitempty"`
+}
+
+// stemPool keeps started a number of generic processes, the stem cells,
+// which run the interpreter without any model: a model not loaded
+// is loaded specializing one of them, and the pool is replenished.
+type stemPool struct {
+	command string
+	args    []string
+	size    int
+	outFile *os.File
+	errFile *os.File
+	// mutex protects the cells
+	mutex sync.Mutex
+	// cells are the stem cells ready to be specialized
+	cells []*modelExecutor
+	// starting is the number of cells being started
+	starting int
+	stopped  bool
+}
+
+func newStemPool(outFile *os.File, errFile *os.File, size int, command string, args ...string) *stemPool {
+	return &stemPool{command, args, size, outFile, errFile, sync.Mutex{}, []*modelExecutor{}, 0, false}
+}
+
+// SetStemCells keeps the given number of stem cells started with the command,
+// to be specialized in the models declaring it. The cells are started in the background.
+func (ap *ActionProxy) SetStemCells(size int, command string, args ...string) {
+	ap.StopStemCells()
+	if size <= 0 {
+		return
+	}
+	ap.stemCells = newStemPool(ap.outFile, ap.errFile, size, command, args...)
+	ap.models.useStemCells(ap.stemCells)
+	ap.stemCells.fill()
+}
+
+// StopStemCells stops the stem cells not specialized yet, if any
+func (ap *ActionProxy) StopStemCells() {
+	if ap.stemCells == nil {
+		return
+	}
+	ap.models.useStemCells(nil)
+	ap.stemCells.stop()
+	ap.stemCells = nil
+}
+
+// fill starts in the background the cells missing to the size of the pool
+func (pool *stemPool) fill() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.stopped {
+		return
+	}
+	for n := len(pool.cells) + pool.starting; n < pool.size; n++ {
+		pool.starting++
+		go pool.startCell()
+	}
+}
+
+// startCell starts a stem cell and adds it to the pool once it acknowledged
+// it is ready. A cell terminating before being specialized is replaced.
+func (pool *stemPool) startCell() {
+	// the cells have the environment of the proxy, the one of the model comes with the specialization
+	cell := NewModelExecutor(pool.outFile, pool.errFile, "stem", true, pool.command, nil, pool.args...)
+	var err error
+	if cell == nil {
+		err = fmt.Errorf("cannot create the stem cell executor")
+	} else {
+		cell.cmd.Env = nil
+		err = cell.Start(true, 0)
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.starting--
+	if err != nil {
+		// not replaced, not to start failing cells in a loop
+		modelLog.Warnf("stem cell not started: %v", err)
+		if cell != nil {
+			cell.Stop()
+		}
+		return
+	}
+	if pool.stopped {
+		cell.Stop()
+		return
+	}
+	pool.cells = append(pool.cells, cell)
+	go func() {
+		<-cell.Done()
+		if pool.remove(cell) {
+			modelLog.Debugf("stem cell %d exited: %s", cell.Pid(), cell.ExitStatus())
+			cell.Stop()
+			pool.fill()
+		}
+	}()
+}
+
+// remove removes the cell from the pool, returning false if it was not there
+func (pool *stemPool) remove(cell *modelExecutor) bool {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for i, c := range pool.cells {
+		if c == cell {
+			pool.cells = append(pool.cells[:i], pool.cells[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// take removes a ready cell from the pool, replenishing it in the background,
+// or returns nil if none is ready
+func (pool *stemPool) take() *modelExecutor {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for len(pool.cells) > 0 {
+		cell := pool.cells[0]
+		pool.cells = pool.cells[1:]
+		if cell.State() == StateReady {
+			go pool.fill()
+			return cell
+		}
+		go cell.Stop()
+	}
+	return nil
+}
+
+// idle returns the number of cells ready to be specialized
+func (pool *stemPool) idle() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return len(pool.cells)
+}
+
+// stop stops the cells ready, the ones being started are stopped when ready
+func (pool *stemPool) stop() {
+	pool.mutex.Lock()
+	cells := pool.cells
+	pool.cells = []*modelExecutor{}
+	pool.stopped = true
+	pool.mutex.Unlock()
+	for _, cell := range cells {
+		cell.Stop()
+	}
+}
+
+// stemCell returns a stem cell to be specialized in the model,
+// or nil if the model does not declare it or no cell is ready
+func (m *Model) stemCell() *modelExecutor {
+	if !m.Stem || m.stemCells == nil {
+		return nil
+	}
+	return m.stemCells.take()
+}
+
+// canSpecialize checks if a stem cell is ready to be specialized in the model
+func (m *Model) canSpecialize() bool {
+	return m.Stem && m.stemCells != nil && m.stemCells.idle() > 0
+}
+
+// specialize sends to the stem cell the load command of the model with its environment,
+// applies the limits of the model, then waits for the cell to be ready as Start does
+func (m *Model) specialize(cell *modelExecutor, env map[string]string) error {
+	modelLog.With("model", m.Name).Debugf("specializing stem cell %d", cell.Pid())
+	env = m.environment(env)
+	cell.name = m.Name
+	cell.grace = time.Duration(m.StopGraceMS) * time.Millisecond
+	cell.limits = m.limits
+	cell.onPhase = m.stats.phase
+	cell.protocol = requestedProtocol(env)
+	line, err := json.Marshal(map[string]specialization{"specialize": {m.Name, m.Load, m.Args, m.Dir, env, m.Entrypoint}})
+	if err != nil {
+		return err
+	}
+	// limited before loading the model
+	cell.limit(cell.prepareLimits())
+	if _, err := cell.input.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to specialize the stem cell: %w", err)
+	}
+	return cell.ready(m.Ack, m.loadTimeout())
+}
@@ -0,0 +1,138 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitCells waits for the pool to have the given number of cells ready
+func waitCells(pool *stemPool, n int) bool {
+	for i := 0; i < 100; i++ {
+		if pool.idle() == n {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func TestStemCells_specialize(t *testing.T) {
+	ap := NewActionProxy("./action/stem", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "stem", Action: "/guest/stem", Load: "_test/loading.sh", Cold: "_test/cold.sh", Ack: true, Stem: true,
+			Env: map[string]string{"LOAD_DELAY": "0"}},
+		{Name: "plain", Action: "/guest/plain", Load: "_test/model.sh", Cold: "_test/cold.sh"},
+	})
+	ap.SetStemCells(1, "_test/stem.py")
+	defer ap.StopStemCells()
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+	assert.True(t, waitCells(ap.stemCells, 1))
+	cell := ap.stemCells.cells[0].Pid()
+	assert.Equal(t, 1, getStatus(t, ts).StemCells)
+
+	// the first request loads the model in the cell
+	body, status, _ := doPost(ts.URL+"/run", `{"action_name":"/guest/stem","value":{}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "fake")
+	m := ap.models.get("stem")
+	m.mutex.RLock()
+	assert.Equal(t, 1, m.replicaCount())
+	assert.Equal(t, cell, m.replicas[0].executor.Pid())
+	m.mutex.RUnlock()
+
+	// the pool is replenished
+	assert.True(t, waitCells(ap.stemCells, 1))
+	assert.NotEqual(t, cell, ap.stemCells.cells[0].Pid())
+
+	// a model not declaring it starts its command
+	assert.False(t, ap.models.get("plain").canSpecialize())
+	_, status, _ = doPost(ts.URL+"/load", `{"action_name":"/guest/plain"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, ap.stemCells.idle())
+}
+
+func TestStemCells_entrypoint(t *testing.T) {
+	ap := NewActionProxy("./action/stem", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "stem", Action: "/guest/stem", Load: "_test/entrypoint.py", Cold: "_test/cold.sh", Args: []string{"--device", "cpu"},
+			Stem: true, Entrypoint: "_test/entrypoint.py"},
+	})
+	ap.SetStemCells(1, "_test/stem.py")
+	defer ap.StopStemCells()
+	defer ap.StopAllExecutorsExcept("")
+	assert.True(t, waitCells(ap.stemCells, 1))
+	cell := ap.stemCells.cells[0].Pid()
+
+	// the model is loaded in the interpreter of the cell, which keeps its pid
+	m := ap.models.get("stem")
+	m.mutex.Lock()
+	assert.Nil(t, m.scale(ap.env, 1))
+	executor := m.replicas[0].executor
+	m.mutex.Unlock()
+	assert.Equal(t, cell, executor.Pid())
+	out, err := executor.Interact(context.Background(), []byte(`{"value": {}}`), 0)
+	assert.Nil(t, err)
+	var answer struct {
+		Pid     int      `json:"pid"`
+		Argv    []string `json:"argv"`
+		Cmdline []string `json:"cmdline"`
+	}
+	assert.Nil(t, json.Unmarshal(out, &answer))
+	assert.Equal(t, cell, answer.Pid)
+	assert.Equal(t, []string{"_test/entrypoint.py", "--device", "cpu"}, answer.Argv)
+	assert.Equal(t, []string{"_test/stem.py"}, answer.Cmdline[len(answer.Cmdline)-1:])
+}
+
+func TestStemCells_exited(t *testing.T) {
+	ap := NewActionProxy("./action/stem", "", nil, nil)
+	ap.SetStemCells(2, "_test/stem.py")
+	assert.True(t, waitCells(ap.stemCells, 2))
+
+	// a cell terminating is replaced
+	ap.stemCells.mutex.Lock()
+	pid := ap.stemCells.cells[0].Pid()
+	ap.stemCells.mutex.Unlock()
+	syscall.Kill(pid, syscall.SIGKILL)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, waitCells(ap.stemCells, 2))
+	ap.stemCells.mutex.Lock()
+	for _, cell := range ap.stemCells.cells {
+		assert.NotEqual(t, pid, cell.Pid())
+	}
+	ap.stemCells.mutex.Unlock()
+
+	// stopping the pool stops the cells
+	pool := ap.stemCells
+	cells := append([]*modelExecutor{}, pool.cells...)
+	ap.StopStemCells()
+	for _, cell := range cells {
+		<-cell.Done()
+	}
+	assert.Equal(t, 0, pool.idle())
+}
@@ -0,0 +1,172 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxRestarts is how many times in a row a crashed replica is restarted
+var DefaultMaxRestarts = 3
+
+// DefaultRestartBackoff is the delay before the first restart of a crashed replica,
+// doubled at each following restart
+var DefaultRestartBackoff = 500 * time.Millisecond
+
+// MaxRestartBackoff is the longest delay before restarting a crashed replica
+var MaxRestartBackoff = 30 * time.Second
+
+// healthPing is the line sent to check an idle replica is alive,
+// the replica must answer with a line
+var healthPing = []byte(`{"ping": true}`)
+
+// maxRestarts is how many times in a row a crashed replica of the model is restarted
+func (m *Model) maxRestarts() int {
+	if m.MaxRestarts == 0 {
+		return DefaultMaxRestarts
+	}
+	return m.MaxRestarts
+}
+
+// restartBackoff is the delay before the first restart of a crashed replica of the model
+func (m *Model) restartBackoff() time.Duration {
+	if m.RestartBackoffMS > 0 {
+		return time.Duration(m.RestartBackoffMS) * time.Millisecond
+	}
+	return DefaultRestartBackoff
+}
+
+// supervise watches a replica until it is removed from the pool.
+// When its process terminates, or does not answer a health ping,
+// it is restarted with an exponential backoff, up to the restart limit.
+func (m *Model) supervise(rep *replica, env map[string]string) {
+	var ping <-chan time.Time
+	if m.HealthIntervalMS > 0 {
+		ticker := time.NewTicker(time.Duration(m.HealthIntervalMS) * time.Millisecond)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+	for {
+		m.mutex.RLock()
+		executor := rep.executor
+		m.mutex.RUnlock()
+
+		select {
+		case <-rep.stopped:
+			return
+		case <-ping:
+			if m.healthy(rep, executor) {
+				continue
+			}
+			modelLog.With("model", m.Name).Warnf("no answer to the health ping")
+			m.stats.fail(fmt.Errorf("%s does not answer the health ping", m.Name))
+		case <-executor.Done():
+			// the crash is decided when the process is reaped,
+			// whoever noticed its termination first
+			m.mutex.RLock()
+			if executor.State() == StateCrashed {
+				m.stats.crashed()
+				err := fmt.Errorf("%s process %d exited: %s", m.Name, executor.Pid(), executor.ExitStatus())
+				if strings.HasPrefix(executor.ExitStatus(), oomStatus) {
+					m.stats.oomKilled(err)
+				} else {
+					m.stats.fail(err)
+				}
+			}
+			m.mutex.RUnlock()
+		}
+		if !m.restart(rep, executor, env) {
+			return
+		}
+	}
+}
+
+// healthy pings the replica if it is idle, and checks it answers
+func (m *Model) healthy(rep *replica, executor ModelExecutor) bool {
+	if atomic.LoadInt32(&rep.inflight) > 0 {
+		return true
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if rep.executor != executor || executor.State() != StateReady {
+		// already being restarted
+		return true
+	}
+	_, err := executor.Interact(context.Background(), healthPing, m.timeout())
+	return err == nil
+}
+
+// restart replaces the failed process of the replica with a new one,
+// waiting longer at each restart in a row. It returns false
+// if the replica was removed from the pool or exceeded the restart limit.
+func (m *Model) restart(rep *replica, failed ModelExecutor, env map[string]string) bool {
+	m.mutex.Lock()
+	if rep.executor == failed {
+		failed.Stop()
+	}
+	m.mutex.Unlock()
+
+	for {
+		restarts := int(atomic.AddInt32(&rep.restarts, 1))
+		max := m.maxRestarts()
+		if max < 0 || restarts > max {
+			modelLog.With("model", m.Name).Errorf("crashed too many times, removing the replica")
+			m.mutex.Lock()
+			m.drop(rep)
+			m.mutex.Unlock()
+			return false
+		}
+		backoff := m.restartBackoff() << uint(restarts-1)
+		if backoff > MaxRestartBackoff || backoff <= 0 {
+			backoff = MaxRestartBackoff
+		}
+		modelLog.With("model", m.Name).Warnf("restarting in %v (restart %d of %d)", backoff, restarts, max)
+		select {
+		case <-rep.stopped:
+			return false
+		case <-time.After(backoff):
+		}
+
+		// load outside the lock, the other replicas keep serving meanwhile
+		executor := m.newExecutor(env)
+		if executor == nil {
+			continue
+		}
+		if err := executor.Start(m.Ack, m.loadTimeout()); err != nil {
+			modelLog.With("model", m.Name).Warnf("cannot restart: %v", err)
+			executor.Stop()
+			continue
+		}
+		m.mutex.Lock()
+		select {
+		case <-rep.stopped:
+			executor.Stop()
+			m.mutex.Unlock()
+			return false
+		default:
+		}
+		rep.executor = executor
+		rep.since = time.Now()
+		m.mutex.Unlock()
+		return true
+	}
+}
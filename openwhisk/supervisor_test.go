@@ -0,0 +1,123 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitReplicas waits until the model has the given number of ready replicas
+func waitReplicas(m *Model, n int) bool {
+	for i := 0; i < 200; i++ {
+		m.mutex.RLock()
+		count := m.replicaCount()
+		m.mutex.RUnlock()
+		if count == n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestSupervisor_restart(t *testing.T) {
+	m := newModel(ModelSpec{Name: "crash", Load: "_test/crash.sh", RestartBackoffMS: 10})
+	assert.Nil(t, m.scale(map[string]string{}, 1))
+	defer m.stop()
+	pid := m.replicas[0].executor.Pid()
+
+	_, err := m.serve(context.Background(), []byte(`{"crash": true}`), 0, 1)
+	assert.Equal(t, ErrExecutorExited, err)
+	assert.True(t, waitReplicas(m, 1))
+	m.mutex.RLock()
+	assert.NotEqual(t, pid, m.replicas[0].executor.Pid())
+	m.mutex.RUnlock()
+	res, err := m.serve(context.Background(), []byte(`{}`), 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"model": "fake"}`, string(res))
+}
+
+func TestSupervisor_maxRestarts(t *testing.T) {
+	m := newModel(ModelSpec{Name: "die", Load: "_test/die.sh", RestartBackoffMS: 10, MaxRestarts: 2})
+	assert.Nil(t, m.scale(map[string]string{}, 1))
+	defer m.stop()
+	for i := 0; i < 200; i++ {
+		m.mutex.RLock()
+		count := len(m.replicas)
+		m.mutex.RUnlock()
+		if count == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.mutex.RLock()
+	assert.Empty(t, m.replicas)
+	m.mutex.RUnlock()
+}
+
+func TestSupervisor_healthPing(t *testing.T) {
+	m := newModel(ModelSpec{Name: "crash", Load: "_test/crash.sh", RestartBackoffMS: 10, HealthIntervalMS: 50, MaxRestarts: -1})
+	assert.Nil(t, m.scale(map[string]string{}, 1))
+	defer m.stop()
+	// the replica crashes when pinged and is not restarted
+	assert.True(t, waitReplicas(m, 0))
+}
+
+func TestSupervisor_coldRetry(t *testing.T) {
+	ap := NewActionProxy("./action/sv", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "crash", Action: "/guest/crash", Load: "_test/crash.sh", RestartBackoffMS: 10, Cold: "_test/cold.sh"},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/crash"}`)
+	assert.Equal(t, http.StatusOK, status)
+	res, status, _ := doPost(ts.URL+"/run", `{"action_name":"/guest/crash","value":{"crash":true}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"model": "cold"}`, strings.TrimSpace(res))
+}
+
+func TestSupervisor_crashState(t *testing.T) {
+	m := newModel(ModelSpec{Name: "crash", Load: "_test/crash.sh"})
+	// a process terminating on its own stays crashed, also once stopped
+	executor := m.newExecutor(map[string]string{})
+	assert.Nil(t, executor.Start(false, 0))
+	_, err := executor.Interact(context.Background(), []byte(`{"crash": true}`), 0)
+	assert.Equal(t, ErrExecutorExited, err)
+	assert.Equal(t, StateCrashed, executor.State())
+	executor.Stop()
+	assert.Equal(t, StateCrashed, executor.State())
+
+	// a process stopped by the proxy did not crash
+	executor = m.newExecutor(map[string]string{})
+	assert.Nil(t, executor.Start(false, 0))
+	assert.Equal(t, StateReady, executor.State())
+	executor.Stop()
+	<-executor.Done()
+	assert.Equal(t, StateUnloaded, executor.State())
+}
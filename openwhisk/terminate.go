@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// DefaultStopGrace is how long a stopped process can take to terminate
+// after SIGTERM, before it is killed with SIGKILL
+var DefaultStopGrace = 2 * time.Second
+
+// terminate stops the process with the given pid, and its whole process group
+// if it was started in one: it sends SIGTERM, waits up to the grace period,
+// DefaultStopGrace if 0, for the process to be reaped, then sends SIGKILL.
+// exited must be closed once the process was reaped: no signal is sent afterwards,
+// as the pid can belong to another process. The proxy itself is never signalled.
+func terminate(name string, pid int, group bool, exited <-chan bool, grace time.Duration) {
+	if pid <= 0 || hasExited(exited) {
+		return
+	}
+	if grace <= 0 {
+		grace = DefaultStopGrace
+	}
+
+	signalProcess(pid, group, syscall.SIGTERM)
+	select {
+	case <-exited:
+		return
+	case <-time.After(grace):
+	}
+	if hasExited(exited) {
+		return
+	}
+	executorLog.Warnf("%s did not terminate in %v, killing it", name, grace)
+	signalProcess(pid, group, syscall.SIGKILL)
+	select {
+	case <-exited:
+	case <-time.After(grace):
+		executorLog.Warnf("%s still not reaped after SIGKILL", name)
+	}
+}
+
+// hasExited checks if the process was reaped, exited being closed then
+func hasExited(exited <-chan bool) bool {
+	select {
+	case <-exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// signalProcess sends the signal to the process with the given pid,
+// or to its process group if it was started in one. The process must not be reaped yet.
+func signalProcess(pid int, group bool, sig syscall.Signal) {
+	if !group {
+		syscall.Kill(pid, sig)
+		return
+	}
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		// already gone
+		return
+	}
+	if pgid == syscall.Getpgrp() {
+		// not in its own group: do not signal the proxy
+		syscall.Kill(pid, sig)
+		return
+	}
+	syscall.Kill(-pgid, sig)
+}
+
+// exitStatus describes how a reaped process terminated:
+// its exit code or the signal that terminated it
+func exitStatus(state *os.ProcessState) string {
+	if state == nil {
+		return ""
+	}
+	return state.String()
+}
@@ -0,0 +1,107 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// alive checks if the process is running, and not a zombie
+func alive(pid int) bool {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// the state follows the command name, in parenthesis
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// childOf asks the stubborn fixture the pid of the process it spawned
+func childOf(t *testing.T, interact func() ([]byte, error)) int {
+	out, err := interact()
+	assert.Nil(t, err)
+	var res struct{ Child int }
+	assert.Nil(t, json.Unmarshal(out, &res))
+	assert.NotEqual(t, 0, res.Child)
+	return res.Child
+}
+
+func TestTerminate_graceful(t *testing.T) {
+	proc := NewModelExecutor(nil, nil, "fake", true, "_test/model.sh", map[string]string{})
+	proc.grace = 5 * time.Second
+	assert.Nil(t, proc.Start(false, 0))
+	assert.Equal(t, "", proc.ExitStatus())
+	start := time.Now()
+	proc.Stop()
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, "signal: terminated", proc.ExitStatus())
+}
+
+func TestTerminate_escalation(t *testing.T) {
+	proc := NewModelExecutor(nil, nil, "stubborn", true, "_test/stubborn.sh", map[string]string{})
+	proc.grace = 200 * time.Millisecond
+	assert.Nil(t, proc.Start(false, 0))
+	child := childOf(t, func() ([]byte, error) { return proc.Interact(context.Background(), []byte("{}"), 0) })
+	assert.True(t, alive(child))
+
+	start := time.Now()
+	proc.Stop()
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	assert.Equal(t, "signal: killed", proc.ExitStatus())
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, alive(child))
+}
+
+func TestTerminate_action(t *testing.T) {
+	log, _ := ioutil.TempFile("", "log")
+	proc := NewExecutor(log, log, "_test/stubborn.sh", map[string]string{})
+	assert.Nil(t, proc.Start(false))
+	child := childOf(t, func() ([]byte, error) { return proc.Interact([]byte("{}")) })
+
+	// the processes spawned by the action are stopped too
+	proc.Stop()
+	assert.Equal(t, "signal: killed", proc.ExitStatus())
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, alive(child))
+}
+
+func TestTerminate_reaped(t *testing.T) {
+	// another process got the pid of the reaped one
+	other := exec.Command("sleep", "60")
+	other.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	assert.Nil(t, other.Start())
+	defer other.Process.Kill()
+	exited := make(chan bool)
+	close(exited)
+
+	for _, group := range []bool{false, true} {
+		terminate("reaped", other.Process.Pid, group, exited, 10*time.Millisecond)
+		assert.True(t, alive(other.Process.Pid))
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout_model(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "slow", Action: "/guest/slow", Load: "_test/slow.sh", Cold: "_test/cold.sh", TimeoutMS: 100, RestartBackoffMS: 10},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+	m := ap.models.get("slow")
+
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/slow"}`)
+	assert.Equal(t, http.StatusOK, status)
+	m.mutex.RLock()
+	pid := m.replicas[0].executor.Pid()
+	m.mutex.RUnlock()
+
+	res, status, _ := doPost(ts.URL+"/run", `{"action_name":"/guest/slow","value":{"sleep":true}}`)
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	assert.Contains(t, res, "timed out")
+
+	// the replica is reloaded, and the late answer is not received
+	assert.True(t, waitReplicas(m, 1))
+	m.mutex.RLock()
+	assert.NotEqual(t, pid, m.replicas[0].executor.Pid())
+	m.mutex.RUnlock()
+	res, status, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/slow","value":{}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"model": "fake"}`, strings.TrimSpace(res))
+
+	// the request can wait longer
+	res, status, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/slow","timeout_ms":3000,"value":{"sleep":true}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"model": "late"}`, strings.TrimSpace(res))
+}
+
+func TestTimeout_header(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "slow", Action: "/guest/slow", Load: "_test/slow.sh", Cold: "_test/cold.sh"},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/slow"}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	run := func(timeout string) int {
+		body := `{"action_name":"/guest/slow","value":{"sleep":true}}`
+		req, _ := http.NewRequest("POST", ts.URL+"/run", bytes.NewBufferString(body))
+		req.Header.Set(TimeoutHeader, timeout)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, http.StatusBadRequest, run("soon"))
+	assert.Equal(t, http.StatusGatewayTimeout, run("100"))
+}
+
+func TestTimeout_cold(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "slow", Action: "/guest/slow", TimeoutMS: 500, Simulate: &SimulatedModel{LoadMS: 5000}},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	start := time.Now()
+	done := make(chan int)
+	go func() {
+		_, status, _ := doPost(ts.URL+"/run", `{"action_name":"/guest/slow","value":{}}`)
+		done <- status
+	}()
+
+	// the cold run does not block the other requests
+	time.Sleep(100 * time.Millisecond)
+	res, err := http.Get(ts.URL + "/status")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	// and it is killed when the timeout expires
+	assert.Equal(t, http.StatusGatewayTimeout, <-done)
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.True(t, ap.models.get("slow").hasCold())
+}
+
+func TestTimeout_coldRequest(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "slow", Action: "/guest/slow", Simulate: &SimulatedModel{LoadMS: 5000}},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	// the timeout of the request bounds the cold run too
+	start := time.Now()
+	_, status, _ := doPost(ts.URL+"/run", `{"action_name":"/guest/slow","value":{},"timeout_ms":300}`)
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	assert.True(t, time.Since(start) < 2*time.Second)
+
+	_, status, _ = doPost(ts.URL+"/run", `{"action_name":"/guest/slow","value":{},"timeout_ms":-1}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
@@ -0,0 +1,220 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimingHeader carries the timing breakdown of a /run request,
+// also sent as a trailer, completed with the time the response was written,
+// when the request accepts trailers with "TE: trailers"
+const TimingHeader = "X-OW-Timing"
+
+// PhasesHeader carries the phases reported by the executor serving a /run request,
+// also sent as a trailer like TimingHeader
+const PhasesHeader = "X-OW-Phases"
+
+// The paths a /run request can take
+const (
+	// PathWarm is a replica of a preloaded model
+	PathWarm = "warm"
+	// PathCold is a cold run of a model, starting its cold command
+	PathCold = "cold"
+	// PathGeneric is the executor of the action
+	PathGeneric = "generic"
+)
+
+// The phases of a /run request, in order
+const (
+	PhaseReceived  = "received"
+	PhaseParsed    = "parsed"
+	PhaseChosen    = "chosen"
+	PhaseSpawned   = "spawned"
+	PhaseFirstByte = "first_byte"
+	PhaseWritten   = "written"
+)
+
+var timingPhases = []string{PhaseReceived, PhaseParsed, PhaseChosen, PhaseSpawned, PhaseFirstByte, PhaseWritten}
+
+// runTiming records when a /run request went through each phase,
+// and the path it took. Its methods do nothing on a nil runTiming,
+// so that requests not timed can be served by the same code.
+type runTiming struct {
+	mutex sync.Mutex
+	start time.Time
+	path  string
+	marks map[string]time.Duration
+	// phases are the ones reported by the executor serving the request, in order
+	phases []reportedPhase
+}
+
+// reportedPhase is a phase reported by an executor, and how long it took
+type reportedPhase struct {
+	name     string
+	duration time.Duration
+}
+
+func newRunTiming() *runTiming {
+	t := &runTiming{start: time.Now(), marks: map[string]time.Duration{}}
+	t.mark(PhaseReceived)
+	return t
+}
+
+// mark records the request reached the phase now,
+// replacing the time of a phase reached again, as when retrying with a cold run
+func (t *runTiming) mark(phase string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.marks[phase] = time.Since(t.start)
+}
+
+// choose records the path taken by the request, and that it was chosen now
+func (t *runTiming) choose(path string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	t.path = path
+	t.mutex.Unlock()
+	t.mark(PhaseChosen)
+}
+
+// phase records a phase reported by the executor serving the request
+func (t *runTiming) phase(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.phases = append(t.phases, reportedPhase{name, d})
+}
+
+// describePhases describes the phases reported by the executor, and how long they took
+// in milliseconds, like "import=812.500;load=2301.200", empty if none
+func (t *runTiming) describePhases() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	fields := []string{}
+	for _, phase := range t.phases {
+		fields = append(fields, fmt.Sprintf("%s=%.3f", phase.name, float64(phase.duration)/float64(time.Millisecond)))
+	}
+	return strings.Join(fields, ";")
+}
+
+// String describes the path and the phases reached, in milliseconds
+// since the request was received, like "path=warm;received=0.000;parsed=0.105"
+func (t *runTiming) String() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	fields := []string{}
+	if t.path != "" {
+		fields = append(fields, "path="+t.path)
+	}
+	for _, phase := range timingPhases {
+		if d, ok := t.marks[phase]; ok {
+			fields = append(fields, fmt.Sprintf("%s=%.3f", phase, float64(d)/float64(time.Millisecond)))
+		}
+	}
+	return strings.Join(fields, ";")
+}
+
+// timingKey is the key of the timing of a request in its context
+type timingKey struct{}
+
+// withTiming attaches the timing to the context of a request
+func withTiming(ctx context.Context, t *runTiming) context.Context {
+	return context.WithValue(ctx, timingKey{}, t)
+}
+
+// timingOf returns the timing of the request, nil if it is not timed
+func timingOf(ctx context.Context) *runTiming {
+	t, _ := ctx.Value(timingKey{}).(*runTiming)
+	return t
+}
+
+// timingWriter adds the timing header to the response when it starts,
+// and the trailer once written if requested
+type timingWriter struct {
+	http.ResponseWriter
+	timing      *runTiming
+	trailer     bool
+	wroteHeader bool
+}
+
+// WriteHeader adds the timing header before writing the status
+func (w *timingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set(TimingHeader, w.timing.String())
+		if phases := w.timing.describePhases(); phases != "" {
+			w.Header().Set(PhasesHeader, phases)
+		}
+		if w.trailer {
+			// trailers are only sent with a chunked response
+			w.Header().Del("Content-Length")
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the body, after the headers
+func (w *timingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the response written so far
+func (w *timingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish records the response was written, and sends the trailer if requested
+func (w *timingWriter) finish() {
+	w.timing.mark(PhaseWritten)
+	if w.trailer && w.wroteHeader {
+		w.Header().Set(http.TrailerPrefix+TimingHeader, w.timing.String())
+		if phases := w.timing.describePhases(); phases != "" {
+			w.Header().Set(http.TrailerPrefix+PhasesHeader, phases)
+		}
+	}
+}
+
+// timeRun times the /run request served by the handler,
+// since it was received if already timed
+func timeRun(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request)) {
+	t := timingOf(r.Context())
+	if t == nil {
+		t = newRunTiming()
+	}
+	tw := &timingWriter{ResponseWriter: w, timing: t, trailer: strings.Contains(strings.ToLower(r.Header.Get("TE")), "trailers")}
+	handler(tw, r.WithContext(withTiming(r.Context(), t)))
+	tw.finish()
+}
@@ -0,0 +1,126 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// postTimed posts a /run request and returns the timing header parsed,
+// and the trailer if requested
+func postTimed(t *testing.T, ts *httptest.Server, body string, trailer bool) (map[string]string, map[string]string) {
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/run", strings.NewReader(body))
+	if trailer {
+		req.Header.Set("TE", "trailers")
+	}
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	return parseTiming(res.Header.Get(TimingHeader)), parseTiming(res.Trailer.Get(TimingHeader))
+}
+
+func parseTiming(header string) map[string]string {
+	res := map[string]string{}
+	for _, field := range strings.Split(header, ";") {
+		if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+			res[kv[0]] = kv[1]
+		}
+	}
+	return res
+}
+
+// assertOrdered checks the phases are reached in order
+func assertOrdered(t *testing.T, timing map[string]string, phases ...string) {
+	last := -1.0
+	for _, phase := range phases {
+		ms, err := strconv.ParseFloat(timing[phase], 64)
+		assert.Nil(t, err, phase)
+		assert.True(t, ms >= last, phase)
+		last = ms
+	}
+}
+
+func TestTiming(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "fake", Action: "/guest/fake", Load: "_test/model.sh", Cold: "_test/cold.sh"},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	// a cold run spawns the process
+	timing, trailer := postTimed(t, ts, `{"action_name":"/guest/fake","value":{}}`, false)
+	assert.Equal(t, "cold", timing["path"])
+	assertOrdered(t, timing, PhaseReceived, PhaseParsed, PhaseChosen, PhaseSpawned, PhaseFirstByte)
+	assert.Equal(t, "", timing[PhaseWritten])
+	assert.Equal(t, 0, len(trailer))
+
+	// a warm run does not, and the trailer adds when the response was written
+	_, status, _ := doPost(ts.URL+"/load", `{"action_name":"/guest/fake"}`)
+	assert.Equal(t, http.StatusOK, status)
+	timing, trailer = postTimed(t, ts, `{"action_name":"/guest/fake","value":{}}`, true)
+	assert.Equal(t, "warm", timing["path"])
+	assertOrdered(t, timing, PhaseReceived, PhaseParsed, PhaseChosen, PhaseFirstByte)
+	assert.Equal(t, "", timing[PhaseSpawned])
+	assert.Equal(t, "warm", trailer["path"])
+	assertOrdered(t, trailer, PhaseReceived, PhaseParsed, PhaseChosen, PhaseFirstByte, PhaseWritten)
+}
+
+func TestTiming_received(t *testing.T) {
+	ap := NewActionProxy("./action/to", "", nil, nil)
+	ap.SetModels([]ModelSpec{
+		{Name: "fake", Action: "/guest/fake", Load: "_test/model.sh", Cold: "_test/cold.sh"},
+	})
+	ap.models.prepare(ap.env)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.StopAllExecutorsExcept("")
+
+	// the time waiting for an /init in progress counts
+	ap.mutex.Lock()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		ap.mutex.Unlock()
+	}()
+	timing, _ := postTimed(t, ts, `{"action_name":"/guest/fake","value":{}}`, false)
+	assert.Equal(t, "0.000", timing[PhaseReceived])
+	parsed, err := strconv.ParseFloat(timing[PhaseParsed], 64)
+	assert.Nil(t, err)
+	assert.True(t, parsed >= 200, fmt.Sprintf("parsed at %v ms", parsed))
+}
+
+func TestTiming_nil(t *testing.T) {
+	// requests not timed are served by the same code
+	var timing *runTiming
+	timing.mark(PhaseParsed)
+	timing.choose(PathGeneric)
+	assert.Nil(t, timingOf(httptest.NewRequest(http.MethodPost, "/run", nil).Context()))
+}
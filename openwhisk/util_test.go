@@ -182,10 +182,14 @@ func removeLineNr(out string) string {
 	return re.ReplaceAllString(out, "::")
 }
 func TestMain(m *testing.M) {
+	// the limited commands are executed, and the simulated models played, by the test binary
+	ExecLimitedCommand()
+	PlaySimulatedModel()
 	Debugging = false // enable debug of tests
 	if !Debugging {
 		// silence those annoying tests
 		log.SetOutput(ioutil.Discard)
+		SetLogOutput(ioutil.Discard)
 		// build support files
 		sys("_test/build.sh")
 		sys("_test/zips.sh")
@@ -22,7 +22,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -102,7 +101,7 @@ func Unzip(src []byte, dest string) error {
 	for _, f := range r.File {
 		err := extractAndWriteFile(f)
 		if err != nil {
-			log.Println(err)
+			extractorLog.Warnf("cannot extract %s: %v", f.Name, err)
 		}
 	}
 	return nil
@@ -0,0 +1,384 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ZygotePollInterval is how often the processes forked by a terminated zygote
+// are checked, as nobody reports their termination anymore
+var ZygotePollInterval = 100 * time.Millisecond
+
+// errZygoteExited is returned when the zygote terminates while forking a process
+var errZygoteExited = errors.New("zygote exited")
+
+// zygoteRequest asks the zygote to fork a process running the command,
+// the files of the process are passed along with it
+type zygoteRequest struct {
+	Path       string       `json:"path"`
+	Args       []string     `json:"args"`
+	Env        []string     `json:"env"`
+	Dir        string       `json:"dir,omitempty"`
+	Group      bool         `json:"group,omitempty"`
+	Entrypoint string       `json:"entrypoint,omitempty"`
+	Limits     *childLimits `json:"limits,omitempty"`
+}
+
+// zygoteMessage is a line written by the zygote: the acknowledgement,
+// the answer to a request or the termination of a forked process
+type zygoteMessage struct {
+	Ok     bool   `json:"ok,omitempty"`
+	Pid    int    `json:"pid,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Exited int    `json:"exited,omitempty"`
+	Code   int    `json:"code,omitempty"`
+	Signal int    `json:"signal,omitempty"`
+}
+
+// status describes how a forked process terminated, like exitStatus
+func (msg *zygoteMessage) status() string {
+	if msg.Signal > 0 {
+		return "signal: " + syscall.Signal(msg.Signal).String()
+	}
+	return fmt.Sprintf("exit status %d", msg.Code)
+}
+
+// zygote is a long-lived process, which imports the shared libraries once,
+// forking the processes of the models. It receives on file descriptor 3
+// a unix socket where it acknowledges it is ready, then gets the requests
+// with the files of the processes to fork, answers with their pids
+// and reports their termination.
+type zygote struct {
+	command string
+	args    []string
+	outFile *os.File
+	errFile *os.File
+	// mutex serializes the requests, and protects the process
+	mutex   sync.Mutex
+	cmd     *exec.Cmd
+	conn    *net.UnixConn
+	replies chan zygoteMessage
+	exited  chan bool
+	// children maps the pids of the processes forked and still running
+	// to the channels receiving how they terminated
+	childMutex sync.Mutex
+	children   map[int]chan string
+}
+
+func newZygote(outFile *os.File, errFile *os.File, command string, args ...string) *zygote {
+	return &zygote{command, args, outFile, errFile, sync.Mutex{}, nil, nil, nil, nil, sync.Mutex{}, map[int]chan string{}}
+}
+
+// StartZygote starts the zygote forking the processes of the models declaring it,
+// waiting for its acknowledgement. A zygote which terminates is started again when needed.
+func (ap *ActionProxy) StartZygote(command string, args ...string) error {
+	z := newZygote(ap.outFile, ap.errFile, command, args...)
+	z.mutex.Lock()
+	err := z.start()
+	z.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	ap.zygote = z
+	ap.models.useZygote(z)
+	return nil
+}
+
+// StopZygote stops the zygote, if any: the models go back to start their processes
+func (ap *ActionProxy) StopZygote() {
+	if ap.zygote == nil {
+		return
+	}
+	ap.models.useZygote(nil)
+	ap.zygote.stop()
+	ap.zygote = nil
+}
+
+// running checks if the zygote is started and did not terminate,
+// the caller must hold the mutex
+func (z *zygote) running() bool {
+	if z.cmd == nil {
+		return false
+	}
+	select {
+	case <-z.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// start starts the zygote and waits for its acknowledgement,
+// the caller must hold the mutex
+func (z *zygote) start() error {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return fmt.Errorf("zygote socket: %w", err)
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	local := os.NewFile(uintptr(fds[0]), "zygote")
+	remote := os.NewFile(uintptr(fds[1]), "zygote")
+	defer local.Close()
+	defer remote.Close()
+	conn, err := net.FileConn(local)
+	if err != nil {
+		return fmt.Errorf("zygote socket: %w", err)
+	}
+
+	cmd := exec.Command(z.command, z.args...)
+	// its own group, so signalling its children never reaches it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if z.outFile != nil {
+		cmd.Stdout = z.outFile
+	}
+	if z.errFile != nil {
+		cmd.Stderr = z.errFile
+	}
+	cmd.ExtraFiles = []*os.File{remote}
+	if err := cmd.Start(); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start zygote: %w", err)
+	}
+	executorLog.Debugf("zygote pid: %d", cmd.Process.Pid)
+	exited := make(chan bool)
+	go func() {
+		cmd.Wait()
+		executorLog.Warnf("zygote terminated: %s", exitStatus(cmd.ProcessState))
+		close(exited)
+	}()
+
+	z.cmd, z.conn, z.exited = cmd, conn.(*net.UnixConn), exited
+	z.replies = make(chan zygoteMessage, 1)
+	ack := make(chan error, 1)
+	go z.read(bufio.NewReader(conn), z.replies, ack)
+	select {
+	case err = <-ack:
+	case <-exited:
+		err = errZygoteExited
+	case <-time.After(DefaultModelTimeoutLoad):
+		err = fmt.Errorf("zygote load %w after %v", ErrTimeout, DefaultModelTimeoutLoad)
+	}
+	if err != nil {
+		z.kill()
+		return err
+	}
+	return nil
+}
+
+// read reads the messages of the zygote until it terminates:
+// the acknowledgement, the answers to the requests and the terminations
+// of the processes, which are watched from the answer on
+func (z *zygote) read(reader *bufio.Reader, replies chan<- zygoteMessage, ack chan<- error) {
+	acked := false
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var msg zygoteMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			executorLog.Debugf("zygote: %v", err)
+			continue
+		}
+		switch {
+		case !acked:
+			acked = true
+			if !msg.Ok {
+				ack <- fmt.Errorf("the zygote did not initialize properly")
+				return
+			}
+			ack <- nil
+		case msg.Exited > 0:
+			z.childMutex.Lock()
+			status, ok := z.children[msg.Exited]
+			delete(z.children, msg.Exited)
+			z.childMutex.Unlock()
+			if ok {
+				status <- msg.status()
+			}
+		default:
+			if msg.Pid > 0 {
+				z.childMutex.Lock()
+				z.children[msg.Pid] = make(chan string, 1)
+				z.childMutex.Unlock()
+			}
+			replies <- msg
+		}
+	}
+	if !acked {
+		ack <- errZygoteExited
+	}
+	close(replies)
+	z.orphaned()
+}
+
+// orphaned watches the processes forked by a zygote which terminated,
+// until they terminate too
+func (z *zygote) orphaned() {
+	z.childMutex.Lock()
+	defer z.childMutex.Unlock()
+	for pid, status := range z.children {
+		go watchOrphan(pid, status)
+	}
+	z.children = map[int]chan string{}
+}
+
+// watchOrphan polls the process forked by a zygote which terminated,
+// reporting when it terminates too
+func watchOrphan(pid int, status chan<- string) {
+	for processAlive(pid) {
+		time.Sleep(ZygotePollInterval)
+	}
+	status <- "exit status unknown, zygote exited"
+}
+
+// processAlive checks if the process with the given pid is running
+func processAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := readProcStat(pid)
+	return err != nil || stat.state != "Z" // zombies are terminated
+}
+
+// spawn asks the zygote to fork a process running the command, in its own process group
+// if group is true, starting the zygote again if it terminated. With an entrypoint
+// the process runs it in the forked interpreter instead of executing the command.
+// The process applies the limits to itself first, if not nil. It returns the pid of the
+// process and a channel receiving how it terminated.
+func (z *zygote) spawn(cmd *exec.Cmd, entrypoint string, group bool, limits *childLimits) (int, <-chan string, error) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	if !z.running() {
+		if z.cmd != nil {
+			z.conn.Close()
+		}
+		if err := z.start(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	files, err := childFiles(cmd)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	req, _ := json.Marshal(zygoteRequest{cmd.Path, cmd.Args, cmd.Env, cmd.Dir, group, entrypoint, limits})
+	_, _, err = z.conn.WriteMsgUnix(append(req, '\n'), syscall.UnixRights(fds...), nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("zygote request: %w", err)
+	}
+
+	select {
+	case msg, ok := <-z.replies:
+		if !ok {
+			return 0, nil, errZygoteExited
+		}
+		if msg.Error != "" || msg.Pid <= 0 {
+			return 0, nil, fmt.Errorf("zygote cannot fork %s: %s", cmd.Path, msg.Error)
+		}
+		z.childMutex.Lock()
+		status, ok := z.children[msg.Pid]
+		z.childMutex.Unlock()
+		if !ok {
+			// the zygote terminated meanwhile
+			status = make(chan string, 1)
+			go watchOrphan(msg.Pid, status)
+		}
+		return msg.Pid, status, nil
+	case <-time.After(DefaultModelTimeoutInteract):
+		// the answer would be read by the next request
+		z.kill()
+		return 0, nil, fmt.Errorf("zygote fork %w after %v", ErrTimeout, DefaultModelTimeoutInteract)
+	}
+}
+
+// childFiles returns the standard input, output and error and the result pipe
+// of the process of the command, /dev/null for those which are not files
+func childFiles(cmd *exec.Cmd) ([]*os.File, error) {
+	files := []*os.File{}
+	for _, f := range []interface{}{cmd.Stdin, cmd.Stdout, cmd.Stderr} {
+		file, _ := f.(*os.File)
+		files = append(files, file)
+	}
+	files = append(files, cmd.ExtraFiles...)
+	res := make([]*os.File, len(files))
+	for i, f := range files {
+		var err error
+		if f == nil {
+			res[i], err = os.OpenFile(os.DevNull, os.O_RDWR, 0)
+		} else {
+			// a copy, as the caller can close the original meanwhile
+			var fd int
+			fd, err = syscall.Dup(int(f.Fd()))
+			if err == nil {
+				syscall.CloseOnExec(fd)
+				res[i] = os.NewFile(uintptr(fd), f.Name())
+			}
+		}
+		if err != nil {
+			for _, f := range res[:i] {
+				f.Close()
+			}
+			return nil, fmt.Errorf("zygote files: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// kill kills the zygote, the caller must hold the mutex
+func (z *zygote) kill() {
+	if z.cmd == nil {
+		return
+	}
+	if !hasExited(z.exited) {
+		signalProcess(z.cmd.Process.Pid, false, syscall.SIGKILL)
+	}
+	z.conn.Close()
+}
+
+// stop terminates the zygote, the processes it forked keep running
+func (z *zygote) stop() {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	if z.cmd == nil {
+		return
+	}
+	terminate("zygote", z.cmd.Process.Pid, false, z.exited, 0)
+	z.conn.Close()
+	z.cmd = nil
+}
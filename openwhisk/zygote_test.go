@@ -0,0 +1,167 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// zygoteModel returns a model forked by a fresh zygote
+func zygoteModel(t *testing.T, spec ModelSpec) (*Model, *zygote) {
+	z := newZygote(nil, nil, "_test/zygote.py")
+	z.mutex.Lock()
+	err := z.start()
+	z.mutex.Unlock()
+	assert.Nil(t, err)
+	spec.Zygote = true
+	reg := newModelRegistry([]ModelSpec{spec}, nil, nil)
+	reg.useZygote(z)
+	return reg.get(spec.Name), z
+}
+
+func TestZygote_load(t *testing.T) {
+	m, z := zygoteModel(t, ModelSpec{Name: "fake", Action: "fake", Load: "_test/loading.sh", Env: map[string]string{"LOAD_DELAY": "0"}})
+	defer z.stop()
+	executor := m.newExecutor(map[string]string{})
+	assert.Nil(t, executor.Start(true, time.Second))
+
+	// forked by the zygote, in its own process group
+	pid := executor.Pid()
+	stat, err := readProcStat(pid)
+	assert.Nil(t, err)
+	assert.Equal(t, z.cmd.Process.Pid, stat.ppid)
+	assert.Equal(t, pid, stat.pgrp)
+
+	out, err := executor.Interact(context.Background(), []byte(`{"value": {}}`), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"model": "fake"}`, string(out))
+
+	executor.Stop()
+	<-executor.Done()
+	assert.Equal(t, "signal: terminated", executor.ExitStatus())
+	assert.False(t, alive(pid))
+}
+
+func TestZygote_cold(t *testing.T) {
+	m, z := zygoteModel(t, ModelSpec{Name: "fake", Action: "fake", Cold: "_test/cold.sh"})
+	defer z.stop()
+	out, err := m.newColdExecutor(map[string]string{}).StartAndWaitForOutput(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"model\": \"cold\"}\n", string(out))
+
+	// a crash is reported with its exit status
+	m.Cold = "_test/die.sh"
+	proc := m.newColdExecutor(map[string]string{})
+	_, err = proc.StartAndWaitForOutput(context.Background(), 0)
+	assert.NotNil(t, err)
+	<-proc.Done()
+	assert.Equal(t, "exit status 1", proc.ExitStatus())
+}
+
+func TestZygote_restart(t *testing.T) {
+	m, z := zygoteModel(t, ModelSpec{Name: "fake", Action: "fake", Load: "_test/model.sh"})
+	defer z.stop()
+	executor := m.newExecutor(map[string]string{})
+	assert.Nil(t, executor.Start(false, 0))
+
+	// the processes forked survive the zygote, and are still watched
+	first := z.cmd.Process.Pid
+	syscall.Kill(first, syscall.SIGKILL)
+	<-z.exited
+	assert.True(t, alive(executor.Pid()))
+	executor.Stop()
+	select {
+	case <-executor.Done():
+	case <-time.After(time.Second):
+		assert.Fail(t, "orphan not watched")
+	}
+
+	// the zygote is started again when needed
+	executor = m.newExecutor(map[string]string{})
+	assert.Nil(t, executor.Start(false, 0))
+	defer executor.Stop()
+	assert.NotEqual(t, first, z.cmd.Process.Pid)
+	stat, _ := readProcStat(executor.Pid())
+	assert.Equal(t, z.cmd.Process.Pid, stat.ppid)
+}
+
+func TestZygote_fallback(t *testing.T) {
+	ap := NewActionProxy("./action/zygote", "", nil, nil)
+	assert.NotNil(t, ap.StartZygote("_test/missing.py"))
+
+	// a zygote which cannot start is bypassed
+	z := newZygote(nil, nil, "_test/missing.py")
+	proc := NewModelExecutor(nil, nil, "fake", true, "_test/model.sh", map[string]string{})
+	proc.zygote = z
+	assert.Nil(t, proc.Start(false, 0))
+	defer proc.Stop()
+	stat, _ := readProcStat(proc.Pid())
+	assert.Equal(t, os.Getpid(), stat.ppid)
+}
+
+func TestZygote_entrypoint(t *testing.T) {
+	m, z := zygoteModel(t, ModelSpec{Name: "fake", Action: "fake", Load: "_test/entrypoint.py", Args: []string{"--device", "cpu"}, Entrypoint: "_test/entrypoint.py"})
+	defer z.stop()
+	executor := m.newExecutor(map[string]string{})
+	assert.Nil(t, executor.Start(false, 0))
+	defer executor.Stop()
+
+	out, err := executor.Interact(context.Background(), []byte(`{"value": {}}`), 0)
+	assert.Nil(t, err)
+	var answer struct {
+		Pid     int      `json:"pid"`
+		Argv    []string `json:"argv"`
+		Cmdline []string `json:"cmdline"`
+	}
+	assert.Nil(t, json.Unmarshal(out, &answer))
+	assert.Equal(t, executor.Pid(), answer.Pid)
+	assert.Equal(t, []string{"_test/entrypoint.py", "--device", "cpu"}, answer.Argv)
+	// the child of the zygote runs the entrypoint without executing it
+	assert.Equal(t, []string{"_test/zygote.py"}, answer.Cmdline[len(answer.Cmdline)-1:])
+	stat, _ := readProcStat(executor.Pid())
+	assert.Equal(t, z.cmd.Process.Pid, stat.ppid)
+
+	err = ValidateModels([]ModelSpec{{Name: "fake", Action: "fake", Load: "_test/entrypoint.py", Cold: "_test/cold.sh", Entrypoint: "_test/entrypoint.py"}})
+	assert.Equal(t, "model fake: entrypoint requires zygote or stem", err.Error())
+}
+
+func TestZygote_limits(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cgroup")
+	defer os.RemoveAll(dir)
+	m, z := zygoteModel(t, ModelSpec{Name: "fake", Action: "fake", Load: "_test/model.sh"})
+	defer z.stop()
+	executor := m.newExecutor(map[string]string{}).(*modelExecutor)
+	executor.limits = &resourceLimits{memory: 100 * megabyte, parent: &cgroupParent{dir: dir}}
+	assert.Nil(t, executor.Start(false, 0))
+	defer executor.Stop()
+
+	// the forked process joined its cgroup itself, before running the command
+	_, err := executor.Interact(context.Background(), []byte(`{}`), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "0", readCgroup(executor.limitedBy().dir, "cgroup.procs"))
+	stat, _ := readProcStat(executor.Pid())
+	assert.Equal(t, z.cmd.Process.Pid, stat.ppid)
+}